@@ -12,11 +12,17 @@ type API struct {
 }
 
 //Setup function sets up the api and returns an api
-func Setup(ctx context.Context, r *mux.Router) *API {
+func Setup(ctx context.Context, r *mux.Router, jr JWKSRetriever, verifyCfg VerifyConfig) *API {
 	api := &API{
 		Router: r,
 	}
-	cjr := CognitoJWKSRetriever{}
-	r.HandleFunc("/{region}/{userPoolId}", UserPoolIdHandler(ctx,cjr)).Methods("GET")
+	// Cognito kids are standard base64, so may hold "/", "//" and escapes that cleaning or decoding the path
+	// before matching it would mangle. The handlers unescape the path variables themselves.
+	r.SkipClean(true)
+	r.UseEncodedPath()
+	r.HandleFunc("/v2/{region}/{userPoolId}", UserPoolIdV2Handler(ctx, jr)).Methods("GET")
+	r.HandleFunc("/{region}/{userPoolId}", UserPoolIdHandler(ctx, jr)).Methods("GET")
+	r.HandleFunc("/{region}/{userPoolId}/{kid:.+}", KidHandler(ctx, jr)).Methods("GET")
+	r.HandleFunc("/verify", VerifyHandler(ctx, jr, verifyCfg)).Methods("POST")
 	return api
 }
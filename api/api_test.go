@@ -2,7 +2,10 @@ package api
 
 import (
 	"context"
+	"io"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gorilla/mux"
@@ -13,14 +16,58 @@ func TestSetup(t *testing.T) {
 	Convey("Given an API instance", t, func() {
 		r := mux.NewRouter()
 		ctx := context.Background()
-		api := Setup(ctx, r)
+		api := Setup(ctx, r, CognitoJWKSRetriever{}, VerifyConfig{})
 
 		Convey("The following routes should have been added", func() {
 			So(hasRoute(api.Router, "/{region}/{{userPoolId}}", "GET"), ShouldBeTrue)
+			So(hasRoute(api.Router, "/v2/{region}/{{userPoolId}}", "GET"), ShouldBeTrue)
+			So(hasRoute(api.Router, "/{region}/{{userPoolId}}/{kid}", "GET"), ShouldBeTrue)
+			So(hasRoute(api.Router, "/verify", "POST"), ShouldBeTrue)
 		})
 	})
 }
 
+func TestSetupPathEscaping(t *testing.T) {
+	Convey("Given an API instance in front of Cognito", t, func() {
+		var requested []string
+		r := mux.NewRouter()
+		Setup(ctx, r, FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+			requested = append(requested, region+" "+userPoolId)
+			return &JWKSResponse{Body: io.NopCloser(strings.NewReader(`{"message":"User pool does not exist."}`)), StatusCode: http.StatusNotFound}, nil
+		}), VerifyConfig{})
+
+		for _, path := range []string{"/eu-west-2/pool%20x", "/v2/eu-west-2/pool%20x", "/eu-west-2/pool%20x/kid"} {
+			Convey("When "+path+" is requested, check the region and user pool ID are unescaped", func() {
+				resp := httptest.NewRecorder()
+				r.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999"+path, nil))
+
+				So(requested, ShouldResemble, []string{"eu-west-2 pool x"})
+				So(resp.Code, ShouldEqual, http.StatusNotFound)
+				So(resp.Body.String(), ShouldContainSubstring, "User pool pool x in region eu-west-2 not found.")
+			})
+		}
+
+		Convey("When a user pool ID is requested escaped and not, check the same user pool ID is retrieved", func() {
+			for _, path := range []string{"/eu-west-2/pool-x", "/eu-west-2/pool%2Dx", "/eu%2Dwest-2/pool-x"} {
+				r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost:25999"+path, nil))
+			}
+			So(requested, ShouldResemble, []string{"eu-west-2 pool-x", "eu-west-2 pool-x", "eu-west-2 pool-x"})
+		})
+	})
+
+	Convey("Given handlers reached with a path variable that is not validly escaped, check a 400 saying so is returned", t, func() {
+		handlers := []http.Handler{UserPoolIdHandler(ctx, MockJWKSRetriever{}), UserPoolIdV2Handler(ctx, MockJWKSRetriever{}), KidHandler(ctx, MockJWKSRetriever{})}
+		for _, handler := range handlers {
+			resp := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "http://localhost:25999/eu-west-2/pool", nil)
+			handler.ServeHTTP(resp, mux.SetURLVars(req, map[string]string{"region": "eu-west-2", "userPoolId": "pool%zz", "kid": "kid"}))
+
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"InvalidRequest","description":"userPoolId is not a valid path segment: invalid URL escape \"%zz\""}]}`)
+		}
+	})
+}
+
 func hasRoute(r *mux.Router, path, method string) bool {
 	req := httptest.NewRequest(method, path, nil)
 	match := &mux.RouteMatch{}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/dp-healthcheck/healthcheck"
+)
+
+// ErrCircuitOpen is returned, without asking Cognito, while the circuit breaker for a region is open
+var ErrCircuitOpen = errors.New("circuit breaker open: requests to AWS Cognito are failing")
+
+// BreakerConfig controls when a CircuitBreakingJWKSRetriever stops sending requests to a region
+type BreakerConfig struct {
+	// FailureThreshold is how many consecutive failures open the breaker. Zero or less disables it.
+	FailureThreshold int
+	// CoolDown is how long the breaker stays open before a single trial request is let through
+	CoolDown time.Duration
+}
+
+// BreakerState is the state of a circuit breaker
+type BreakerState int
+
+const (
+	// BreakerClosed lets requests through, counting consecutive failures
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails requests fast until the cool-down has passed
+	BreakerOpen
+	// BreakerHalfOpen lets a single trial request through, whose outcome closes or reopens the breaker
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// maxUnprovenBreakers is the most breakers held for regions that have never served keys, which may be made up, so
+// that requests for made-up regions cannot grow the breakers without limit
+const maxUnprovenBreakers = 100
+
+// CircuitBreakingJWKSRetriever decorates a JWKSRetriever with a circuit breaker per region, so that while
+// Cognito is failing in a region requests fail fast with ErrCircuitOpen rather than each waiting to time out
+type CircuitBreakingJWKSRetriever struct {
+	retriever JWKSRetriever
+	cfg       BreakerConfig
+	now       func() time.Time
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+type circuitBreaker struct {
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trialing bool
+	// succeeded is whether the region has ever served keys, so that it is known to be served by Cognito
+	succeeded bool
+}
+
+// NewCircuitBreakingJWKSRetriever returns a CircuitBreakingJWKSRetriever in front of the given retriever
+func NewCircuitBreakingJWKSRetriever(jr JWKSRetriever, cfg BreakerConfig) *CircuitBreakingJWKSRetriever {
+	return &CircuitBreakingJWKSRetriever{
+		retriever: jr,
+		cfg:       cfg,
+		now:       time.Now,
+		breakers:  make(map[string]*circuitBreaker),
+	}
+}
+
+// RetrieveJWKS requests the JWKS unless the region's breaker is open. Network errors, 429 and 5xx responses count
+// as failures; requests abandoned by their caller, or failing in a way that asking again cannot fix, count as neither
+// failure nor success. A region only has a breaker
+// once a request to it has failed or served keys, so that requests for made-up regions leave nothing behind.
+func (b *CircuitBreakingJWKSRetriever) RetrieveJWKS(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+	if b.cfg.FailureThreshold <= 0 {
+		return b.retriever.RetrieveJWKS(ctx, region, userPoolId, prev)
+	}
+	if !b.allow(region) {
+		return nil, ErrCircuitOpen
+	}
+	resp, err := b.retriever.RetrieveJWKS(ctx, region, userPoolId, prev)
+	b.record(ctx, region, resp, err)
+	return resp, err
+}
+
+// States returns the state of the breaker of every region that has one
+func (b *CircuitBreakingJWKSRetriever) States() map[string]BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	states := make(map[string]BreakerState, len(b.breakers))
+	for region, cb := range b.breakers {
+		states[region] = cb.state
+	}
+	return states
+}
+
+// Checker reports the breakers to the healthcheck: WARNING while the breaker of any region that has ever served keys
+// is not closed, otherwise OK. Regions that have never served keys, which may not be served by Cognito at all, are left
+// out so that requests for them cannot make the service look degraded.
+func (b *CircuitBreakingJWKSRetriever) Checker(ctx context.Context, state *healthcheck.CheckState) error {
+	var notClosed []string
+	b.mu.Lock()
+	for region, cb := range b.breakers {
+		if cb.succeeded && cb.state != BreakerClosed {
+			notClosed = append(notClosed, fmt.Sprintf("%s (%s)", region, cb.state))
+		}
+	}
+	b.mu.Unlock()
+	if len(notClosed) == 0 {
+		return state.Update(healthcheck.StatusOK, "circuit breakers for AWS Cognito are closed", 0)
+	}
+	sort.Strings(notClosed)
+	return state.Update(healthcheck.StatusWarning, "circuit breakers for AWS Cognito are not closed in regions: "+strings.Join(notClosed, ", "), 0)
+}
+
+// allow reports whether a request may be made to the region, moving an open breaker whose cool-down has passed to
+// half-open and letting through only one request while half-open
+func (b *CircuitBreakingJWKSRetriever) allow(region string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cb, ok := b.breakers[region]
+	if !ok {
+		return true
+	}
+	switch cb.state {
+	case BreakerOpen:
+		if b.now().Sub(cb.openedAt) < b.cfg.CoolDown {
+			return false
+		}
+		cb.state = BreakerHalfOpen
+		cb.trialing = true
+		return true
+	case BreakerHalfOpen:
+		if cb.trialing {
+			return false
+		}
+		cb.trialing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the region's breaker with the outcome of a request
+func (b *CircuitBreakingJWKSRetriever) record(ctx context.Context, region string, resp *JWKSResponse, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cb, ok := b.breakers[region]
+	switch {
+	case ctx.Err() != nil:
+		if ok {
+			cb.trialing = false
+		}
+	case isUpstreamFailure(resp, err):
+		if !ok {
+			if cb = b.newBreaker(region); cb == nil {
+				return
+			}
+		}
+		cb.failures++
+		if cb.state == BreakerHalfOpen || cb.failures >= b.cfg.FailureThreshold {
+			cb.state = BreakerOpen
+			cb.openedAt = b.now()
+		}
+		cb.trialing = false
+	case err != nil:
+		// a failure that asking again cannot fix says nothing about whether Cognito is up
+		if ok {
+			cb.trialing = false
+		}
+	default:
+		served := resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotModified
+		if served || ok && cb.succeeded {
+			b.breakers[region] = &circuitBreaker{succeeded: true}
+		} else {
+			// a closed breaker of a region that has never served keys holds nothing worth keeping
+			delete(b.breakers, region)
+		}
+	}
+}
+
+// newBreaker adds a breaker for a region that has never served keys, unless there are already maxUnprovenBreakers. To
+// make room, breakers of such regions that are closed or whose cool-down has passed are first removed.
+func (b *CircuitBreakingJWKSRetriever) newBreaker(region string) *circuitBreaker {
+	if b.unproven() >= maxUnprovenBreakers {
+		for r, cb := range b.breakers {
+			if !cb.succeeded && (cb.state == BreakerClosed || b.now().Sub(cb.openedAt) >= b.cfg.CoolDown) {
+				delete(b.breakers, r)
+			}
+		}
+		if b.unproven() >= maxUnprovenBreakers {
+			return nil
+		}
+	}
+	cb := &circuitBreaker{}
+	b.breakers[region] = cb
+	return cb
+}
+
+// unproven counts the breakers of regions that have never served keys
+func (b *CircuitBreakingJWKSRetriever) unproven() int {
+	n := 0
+	for _, cb := range b.breakers {
+		if !cb.succeeded {
+			n++
+		}
+	}
+	return n
+}
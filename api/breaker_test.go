@@ -0,0 +1,287 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-healthcheck/healthcheck"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// StatusJWKSRetriever responds with an empty body and the given status
+type StatusJWKSRetriever int
+
+func (sjr StatusJWKSRetriever) RetrieveJWKS(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+	return &JWKSResponse{Body: http.NoBody, StatusCode: int(sjr)}, nil
+}
+
+func TestCircuitBreakingJWKSRetriever(t *testing.T) {
+	Convey("Given a circuit breaking retriever in front of a failing Cognito", t, func() {
+		now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+		sjr := &SwitchableJWKSRetriever{current: JWKSRetrieverHttpErr{}}
+		cjr := &CountingJWKSRetriever{JWKSRetriever: sjr}
+		b := NewCircuitBreakingJWKSRetriever(cjr, BreakerConfig{FailureThreshold: 3, CoolDown: 30 * time.Second})
+		b.now = func() time.Time { return now }
+		retrieve := func(region string) error {
+			_, err := b.RetrieveJWKS(ctx, region, "pool", ResponseMetadata{})
+			return err
+		}
+
+		Convey("When fewer failures than the threshold have happened, check the breaker stays closed", func() {
+			retrieve("eu-west-2")
+			retrieve("eu-west-2")
+			So(b.States()["eu-west-2"], ShouldEqual, BreakerClosed)
+			So(retrieve("eu-west-2"), ShouldNotEqual, ErrCircuitOpen)
+			So(cjr.Calls(), ShouldEqual, 3)
+		})
+
+		Convey("When a success comes between failures, check the count starts again", func() {
+			retrieve("eu-west-2")
+			retrieve("eu-west-2")
+			sjr.SwitchTo(MockJWKSRetriever{})
+			retrieve("eu-west-2")
+			sjr.SwitchTo(JWKSRetrieverHttpErr{})
+			retrieve("eu-west-2")
+			retrieve("eu-west-2")
+			So(b.States()["eu-west-2"], ShouldEqual, BreakerClosed)
+		})
+
+		Convey("When server errors and throttling reach the threshold, check the breaker opens", func() {
+			sjr.SwitchTo(StatusJWKSRetriever(http.StatusServiceUnavailable))
+			retrieve("eu-west-2")
+			sjr.SwitchTo(StatusJWKSRetriever(http.StatusTooManyRequests))
+			retrieve("eu-west-2")
+			retrieve("eu-west-2")
+			So(b.States()["eu-west-2"], ShouldEqual, BreakerOpen)
+		})
+
+		Convey("When unknown user pools are requested, check they do not count as failures", func() {
+			sjr.SwitchTo(JWKSRetrieverError{})
+			for i := 0; i < 5; i++ {
+				retrieve("eu-west-2")
+			}
+			So(b.States()["eu-west-2"], ShouldEqual, BreakerClosed)
+		})
+
+		Convey("When requests are abandoned by their callers, check they do not count as failures", func() {
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			for i := 0; i < 5; i++ {
+				b.RetrieveJWKS(cancelled, "eu-west-2", "pool", ResponseMetadata{})
+			}
+			So(b.States()["eu-west-2"], ShouldEqual, BreakerClosed)
+		})
+
+		Convey("When requests fail in a way that asking again cannot fix, check they do not count as failures", func() {
+			sjr.SwitchTo(FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+				return nil, newUpstreamError(x509.UnknownAuthorityError{})
+			}))
+			for i := 0; i < 5; i++ {
+				retrieve("eu-west-2")
+			}
+			So(b.States(), ShouldBeEmpty)
+		})
+
+		Convey("When the threshold is reached", func() {
+			for i := 0; i < 3; i++ {
+				retrieve("eu-west-2")
+			}
+
+			Convey("Then requests to the region fail fast without asking Cognito", func() {
+				So(retrieve("eu-west-2"), ShouldEqual, ErrCircuitOpen)
+				So(cjr.Calls(), ShouldEqual, 3)
+			})
+
+			Convey("Then requests to other regions are still made", func() {
+				So(retrieve("eu-west-1"), ShouldNotEqual, ErrCircuitOpen)
+				So(cjr.Calls(), ShouldEqual, 4)
+			})
+
+			Convey("Then once the cool-down has passed a single trial request is let through", func() {
+				now = now.Add(30 * time.Second)
+				So(b.allow("eu-west-2"), ShouldBeTrue)
+				So(b.States()["eu-west-2"], ShouldEqual, BreakerHalfOpen)
+				So(retrieve("eu-west-2"), ShouldEqual, ErrCircuitOpen)
+			})
+
+			Convey("Then a successful trial request closes the breaker", func() {
+				now = now.Add(30 * time.Second)
+				sjr.SwitchTo(MockJWKSRetriever{})
+				So(retrieve("eu-west-2"), ShouldBeNil)
+				So(b.States()["eu-west-2"], ShouldEqual, BreakerClosed)
+				So(retrieve("eu-west-2"), ShouldBeNil)
+			})
+
+			Convey("Then a failed trial request reopens the breaker for another cool-down", func() {
+				now = now.Add(30 * time.Second)
+				So(retrieve("eu-west-2"), ShouldNotEqual, ErrCircuitOpen)
+				So(b.States()["eu-west-2"], ShouldEqual, BreakerOpen)
+				now = now.Add(29 * time.Second)
+				So(retrieve("eu-west-2"), ShouldEqual, ErrCircuitOpen)
+			})
+
+			Convey("Then an abandoned trial request lets another trial through", func() {
+				now = now.Add(30 * time.Second)
+				cancelled, cancel := context.WithCancel(ctx)
+				cancel()
+				b.RetrieveJWKS(cancelled, "eu-west-2", "pool", ResponseMetadata{})
+				So(b.States()["eu-west-2"], ShouldEqual, BreakerHalfOpen)
+				So(retrieve("eu-west-2"), ShouldNotEqual, ErrCircuitOpen)
+			})
+		})
+	})
+
+	Convey("Given a circuit breaking retriever with the breakers disabled, check requests are always made", t, func() {
+		cjr := &CountingJWKSRetriever{JWKSRetriever: JWKSRetrieverHttpErr{}}
+		b := NewCircuitBreakingJWKSRetriever(cjr, BreakerConfig{})
+		for i := 0; i < 10; i++ {
+			_, err := b.RetrieveJWKS(ctx, "eu-west-2", "pool", ResponseMetadata{})
+			So(err, ShouldNotEqual, ErrCircuitOpen)
+		}
+		So(cjr.Calls(), ShouldEqual, 10)
+	})
+}
+
+func TestCircuitBreakingJWKSRetrieverChecker(t *testing.T) {
+	Convey("Given a circuit breaking retriever", t, func() {
+		b := NewCircuitBreakingJWKSRetriever(JWKSRetrieverHttpErr{}, BreakerConfig{FailureThreshold: 1, CoolDown: time.Minute})
+		state := healthcheck.NewCheckState("AWS Cognito circuit breakers")
+
+		Convey("When every breaker is closed, check the state is OK", func() {
+			b.RetrieveJWKS(ctx, "eu-west-2", "pool", ResponseMetadata{})
+			b.breakers["eu-west-2"] = &circuitBreaker{}
+			So(b.Checker(ctx, state), ShouldBeNil)
+			So(state.Status(), ShouldEqual, healthcheck.StatusOK)
+			So(state.Message(), ShouldEqual, "circuit breakers for AWS Cognito are closed")
+		})
+
+		Convey("When breakers of regions that have succeeded are open, check the state is WARNING and names their regions", func() {
+			b.breakers["eu-west-2"] = &circuitBreaker{succeeded: true}
+			b.breakers["eu-west-1"] = &circuitBreaker{succeeded: true}
+			b.RetrieveJWKS(ctx, "eu-west-2", "pool", ResponseMetadata{})
+			b.RetrieveJWKS(ctx, "eu-west-1", "pool", ResponseMetadata{})
+			So(b.Checker(ctx, state), ShouldBeNil)
+			So(state.Status(), ShouldEqual, healthcheck.StatusWarning)
+			So(state.Message(), ShouldEqual, "circuit breakers for AWS Cognito are not closed in regions: eu-west-1 (open), eu-west-2 (open)")
+		})
+
+		Convey("When the breaker of a region that has never succeeded is open, check the state is OK", func() {
+			b.RetrieveJWKS(ctx, "xx-made-up-1", "pool", ResponseMetadata{})
+			So(b.States()["xx-made-up-1"], ShouldEqual, BreakerOpen)
+			So(b.Checker(ctx, state), ShouldBeNil)
+			So(state.Status(), ShouldEqual, healthcheck.StatusOK)
+		})
+	})
+
+	Convey("Given a circuit breaking retriever, check regions that are not AWS regions are still requested", t, func() {
+		for _, cfg := range []BreakerConfig{{}, {FailureThreshold: 1, CoolDown: time.Minute}} {
+			cjr := &CountingJWKSRetriever{JWKSRetriever: MockJWKSRetriever{}}
+			b := NewCircuitBreakingJWKSRetriever(cjr, cfg)
+			resp, err := b.RetrieveJWKS(ctx, "local", "local_abc", ResponseMetadata{})
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(cjr.Calls(), ShouldEqual, 1)
+		}
+	})
+}
+
+func TestCircuitBreakingJWKSRetrieverBreakers(t *testing.T) {
+	Convey("Given a circuit breaking retriever", t, func() {
+		now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+		sjr := &SwitchableJWKSRetriever{current: JWKSRetrieverError{}}
+		b := NewCircuitBreakingJWKSRetriever(sjr, BreakerConfig{FailureThreshold: 3, CoolDown: time.Minute})
+		b.now = func() time.Time { return now }
+
+		Convey("When regions answer without serving keys, check they are left without a breaker", func() {
+			b.RetrieveJWKS(ctx, "aa-b-1", "pool", ResponseMetadata{})
+			So(b.States(), ShouldBeEmpty)
+		})
+
+		Convey("When a region that has failed answers without serving keys, check its breaker is removed", func() {
+			sjr.SwitchTo(JWKSRetrieverHttpErr{})
+			b.RetrieveJWKS(ctx, "aa-b-1", "pool", ResponseMetadata{})
+			So(b.States(), ShouldContainKey, "aa-b-1")
+			sjr.SwitchTo(JWKSRetrieverError{})
+			b.RetrieveJWKS(ctx, "aa-b-1", "pool", ResponseMetadata{})
+			So(b.States(), ShouldBeEmpty)
+		})
+
+		Convey("When a region serves keys, check its breaker is kept", func() {
+			sjr.SwitchTo(MockJWKSRetriever{})
+			b.RetrieveJWKS(ctx, "eu-west-2", "pool", ResponseMetadata{})
+			sjr.SwitchTo(JWKSRetrieverError{})
+			b.RetrieveJWKS(ctx, "eu-west-2", "pool", ResponseMetadata{})
+			So(b.States(), ShouldResemble, map[string]BreakerState{"eu-west-2": BreakerClosed})
+		})
+
+		Convey("When more made-up regions fail than breakers are held for them", func() {
+			sjr.SwitchTo(MockJWKSRetriever{})
+			b.RetrieveJWKS(ctx, "eu-west-2", "pool", ResponseMetadata{})
+			sjr.SwitchTo(JWKSRetrieverHttpErr{})
+			for i := 0; i < 3*maxUnprovenBreakers; i++ {
+				region := "xx-made-up-" + strconv.Itoa(i)
+				for j := 0; j < 3; j++ {
+					b.RetrieveJWKS(ctx, region, "pool", ResponseMetadata{})
+				}
+			}
+
+			Convey("Then no more than the limit are held, besides those of regions that have served keys", func() {
+				So(b.States(), ShouldHaveLength, maxUnprovenBreakers+1)
+				So(b.States(), ShouldContainKey, "eu-west-2")
+			})
+
+			Convey("Then once their cool-down has passed they make room for others", func() {
+				now = now.Add(time.Minute)
+				b.RetrieveJWKS(ctx, "xx-made-up-new", "pool", ResponseMetadata{})
+				So(b.States(), ShouldHaveLength, 2)
+				So(b.States(), ShouldContainKey, "xx-made-up-new")
+				So(b.States(), ShouldContainKey, "eu-west-2")
+			})
+		})
+	})
+}
+
+func TestUserPoolIdHandlerCircuitOpen(t *testing.T) {
+	Convey("Given a user pool id handler backed by a cache in front of an open circuit breaker", t, func() {
+		now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+		sjr := &SwitchableJWKSRetriever{current: MockJWKSRetriever{}}
+		b := NewCircuitBreakingJWKSRetriever(sjr, BreakerConfig{FailureThreshold: 1, CoolDown: time.Hour})
+		b.now = func() time.Time { return now }
+		cache := NewCachingJWKSRetriever(b, CacheConfig{TTL: time.Minute, MaxStale: time.Minute})
+		cache.now = func() time.Time { return now }
+		userPoolIdHandler := UserPoolIdHandler(ctx, cache)
+		serve := func(path string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			userPoolIdHandler.ServeHTTP(resp, mux.SetURLVars(httptest.NewRequest("GET", path, nil), map[string]string{"region": "eu-west-2", "userPoolId": "userPoolId"}))
+			return resp
+		}
+		serve("http://localhost:25999/region/userPoolId")
+		sjr.SwitchTo(JWKSRetrieverHttpErr{})
+		now = now.Add(5 * time.Minute)
+		serve("http://localhost:25999/region/userPoolId")
+		So(b.States()["eu-west-2"], ShouldEqual, BreakerOpen)
+
+		Convey("When keys are cached, check they are served as stale even past the maximum staleness", func() {
+			now = now.Add(30 * time.Minute)
+			resp := serve("http://localhost:25999/region/userPoolId")
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get("Warning"), ShouldEqual, `110 - "Response is Stale"`)
+		})
+	})
+
+	Convey("Given a user pool id handler in front of an open circuit breaker without cached keys, check it fails fast with 503", t, func() {
+		b := NewCircuitBreakingJWKSRetriever(JWKSRetrieverHttpErr{}, BreakerConfig{FailureThreshold: 1, CoolDown: time.Hour})
+		b.RetrieveJWKS(ctx, "eu-west-2", "", ResponseMetadata{})
+		userPoolIdHandler := UserPoolIdHandler(ctx, NewCachingJWKSRetriever(b, CacheConfig{TTL: time.Minute}))
+		resp := httptest.NewRecorder()
+		userPoolIdHandler.ServeHTTP(resp, mux.SetURLVars(httptest.NewRequest("GET", "http://localhost:25999/eu-west-2/userPoolId", nil), map[string]string{"region": "eu-west-2", "userPoolId": "userPoolId"}))
+		So(resp.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"UpstreamUnavailable","description":"AWS Cognito is currently unavailable. Try again later."}]}`)
+	})
+}
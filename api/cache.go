@@ -0,0 +1,292 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CacheConfig controls how long a CachingJWKSRetriever keeps and serves converted keys
+type CacheConfig struct {
+	// TTL is how long keys are served before Cognito is asked again, unless Cognito advertises a max-age of its
+	// own. Zero or less disables caching.
+	TTL time.Duration
+	// MinTTL and MaxTTL, when greater than zero, bound the lifetime advertised by Cognito
+	MinTTL time.Duration
+	MaxTTL time.Duration
+	// MaxStale is how long past their TTL keys may still be served while they cannot be refreshed
+	MaxStale time.Duration
+	// KidRefreshInterval is the least time between refreshes of a user pool's keys forced by requests for a kid it
+	// does not have. Zero or less disables forced refreshes.
+	KidRefreshInterval time.Duration
+	// Conversion controls how retrieved JWKS are converted into the keys that are cached
+	Conversion ConversionConfig
+}
+
+// CachingJWKSRetriever decorates a JWKSRetriever with an in-memory cache of converted public keys,
+// keyed on region and user pool ID, so that repeat requests skip both Cognito and the conversion.
+// Concurrent misses for the same user pool share a single upstream request and its result. Expired
+// keys are revalidated with a conditional request, and served as stale, for up to MaxStale, when they
+// cannot be refreshed, or for as long as they are held while the circuit breaker is open.
+type CachingJWKSRetriever struct {
+	retriever JWKSRetriever
+	cfg       CacheConfig
+	now       func() time.Time
+	inFlight  flightGroup
+
+	mu      sync.RWMutex
+	entries map[cacheKey]cacheEntry
+	forced  map[cacheKey]time.Time
+
+	stopRefresher context.CancelFunc
+	refresherDone chan struct{}
+}
+
+type cacheKey struct {
+	region     string
+	userPoolId string
+}
+
+func (k cacheKey) String() string {
+	return k.region + "/" + k.userPoolId
+}
+
+type cacheEntry struct {
+	keySet    KeySet
+	fetchedAt time.Time
+	ttl       time.Duration
+	metadata  ResponseMetadata
+}
+
+// ErrRefreshSkipped is returned by RefreshKeys when the keys are not refreshed, as they are too recent or forced
+// refreshes are disabled
+var ErrRefreshSkipped = errors.New("forced refresh skipped")
+
+// KeySet holds the converted public keys for a user pool, by kid, the keys left out of it and why, and how fresh
+// they are
+type KeySet struct {
+	Keys      map[string]PublicKey
+	KeyErrors []KeyError
+	Age       time.Duration
+	Stale     bool
+}
+
+// NewCachingJWKSRetriever returns a CachingJWKSRetriever in front of the given retriever
+func NewCachingJWKSRetriever(jr JWKSRetriever, cfg CacheConfig) *CachingJWKSRetriever {
+	return &CachingJWKSRetriever{
+		retriever: jr,
+		cfg:       cfg,
+		now:       time.Now,
+		entries:   make(map[cacheKey]cacheEntry),
+		forced:    make(map[cacheKey]time.Time),
+	}
+}
+
+// RetrieveJWKS passes the request through to the decorated retriever, bypassing the cache
+func (c *CachingJWKSRetriever) RetrieveJWKS(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+	return c.retriever.RetrieveJWKS(ctx, region, userPoolId, prev)
+}
+
+// RetrieveKeys returns the converted keys for a user pool, fetching and caching them if there is no unexpired
+// entry. If they cannot be fetched, an expired entry is returned as stale for as long as MaxStale allows.
+func (c *CachingJWKSRetriever) RetrieveKeys(ctx context.Context, region, userPoolId string) (KeySet, error) {
+	key := cacheKey{region: region, userPoolId: userPoolId}
+	entry, cached := c.get(key)
+	age := c.now().Sub(entry.fetchedAt)
+	if cached && age < entry.ttl {
+		keySet := entry.keySet
+		keySet.Age = age
+		return keySet, nil
+	}
+	keySet, err := c.fetch(ctx, key)
+	if err == nil {
+		return keySet, nil
+	}
+	if ctx.Err() != nil {
+		return KeySet{}, err
+	}
+	if err == ErrUserPoolNotFound {
+		c.delete(key)
+		return KeySet{}, err
+	}
+	if cached && (age < entry.ttl+c.cfg.MaxStale || err == ErrCircuitOpen) {
+		log.Printf("Serving stale keys for user pool %s in region %s after refresh failed.\nError:%s\n", userPoolId, region, err.Error())
+		keySet := entry.keySet
+		keySet.Age, keySet.Stale = age, true
+		return keySet, nil
+	}
+	return KeySet{}, err
+}
+
+// RefreshKeys re-fetches the keys for a user pool before they expire, for when a kid they do not have is requested,
+// in case Cognito has just rotated it in. So that unknown kids cannot flood Cognito, ErrRefreshSkipped is returned
+// instead if the keys were fetched, or a refresh was forced, within KidRefreshInterval.
+func (c *CachingJWKSRetriever) RefreshKeys(ctx context.Context, region, userPoolId string) (KeySet, error) {
+	key := cacheKey{region: region, userPoolId: userPoolId}
+	if !c.allowForcedRefresh(key) {
+		return KeySet{}, ErrRefreshSkipped
+	}
+	return c.fetch(ctx, key)
+}
+
+// allowForcedRefresh reports whether a refresh may be forced, recording it if so. Uncached keys are never forced,
+// as they were fetched for the request.
+func (c *CachingJWKSRetriever) allowForcedRefresh(key cacheKey) bool {
+	if c.cfg.KidRefreshInterval <= 0 || c.cfg.TTL <= 0 {
+		return false
+	}
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok && now.Sub(entry.fetchedAt) < c.cfg.KidRefreshInterval {
+		return false
+	}
+	if forcedAt, ok := c.forced[key]; ok && now.Sub(forcedAt) < c.cfg.KidRefreshInterval {
+		return false
+	}
+	c.forced[key] = now
+	return true
+}
+
+// StartRefresher launches a goroutine that, every interval, re-fetches the keys of cached user pools that would
+// otherwise expire before the next run. Failed refreshes leave the existing keys in place to be served as stale.
+func (c *CachingJWKSRetriever) StartRefresher(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopRefresher = cancel
+	c.refresherDone = make(chan struct{})
+	go func() {
+		defer close(c.refresherDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.refresh(ctx, interval)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// StopRefresher stops the goroutine started by StartRefresher, cancelling any refresh in progress, and waits for
+// every fetch in flight to return
+func (c *CachingJWKSRetriever) StopRefresher() {
+	if c.stopRefresher == nil {
+		return
+	}
+	c.stopRefresher()
+	<-c.refresherDone
+	c.inFlight.wait()
+	c.stopRefresher = nil
+}
+
+// refresh re-fetches every entry due to expire within the given window and evicts those too old to be served
+func (c *CachingJWKSRetriever) refresh(ctx context.Context, window time.Duration) {
+	now := c.now()
+	var due []cacheKey
+	c.mu.Lock()
+	for key, entry := range c.entries {
+		age := now.Sub(entry.fetchedAt)
+		if age >= entry.ttl+c.cfg.MaxStale {
+			delete(c.entries, key)
+			delete(c.forced, key)
+			continue
+		}
+		if age+window >= entry.ttl {
+			due = append(due, key)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, key := range due {
+		if _, err := c.fetch(ctx, key); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Failed to refresh keys for user pool %s in region %s.\nError:%s\n", key.userPoolId, key.region, err.Error())
+			if err == ErrUserPoolNotFound {
+				c.delete(key)
+			}
+		}
+	}
+}
+
+// fetch retrieves and converts a user pool's keys and caches them, sharing the request with concurrent callers.
+// If the keys are already cached the request is conditional, and a 304 response keeps the cached keys.
+func (c *CachingJWKSRetriever) fetch(ctx context.Context, key cacheKey) (KeySet, error) {
+	return c.inFlight.do(ctx, key.String(), func(ctx context.Context) (KeySet, error) {
+		entry, cached := c.get(key)
+		resp, err := c.retriever.RetrieveJWKS(ctx, key.region, key.userPoolId, entry.metadata)
+		if err != nil {
+			return KeySet{}, err
+		}
+		defer resp.Body.Close()
+		if cached && resp.StatusCode == http.StatusNotModified {
+			c.set(key, entry.keySet, mergeMetadata(entry.metadata, resp.Metadata))
+			return entry.keySet, nil
+		}
+		keySet, err := readKeys(resp, c.cfg.Conversion)
+		if err != nil {
+			return KeySet{}, err
+		}
+		c.set(key, keySet, resp.Metadata)
+		return keySet, nil
+	})
+}
+
+func (c *CachingJWKSRetriever) get(key cacheKey) (cacheEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+func (c *CachingJWKSRetriever) set(key cacheKey, keySet KeySet, metadata ResponseMetadata) {
+	if c.cfg.TTL <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = cacheEntry{keySet: keySet, fetchedAt: c.now(), ttl: c.ttl(metadata), metadata: metadata}
+}
+
+// ttl returns how long a response may be cached for: its advertised max-age within the configured bounds,
+// or the configured TTL if it does not advertise one
+func (c *CachingJWKSRetriever) ttl(metadata ResponseMetadata) time.Duration {
+	if !metadata.HasMaxAge {
+		return c.cfg.TTL
+	}
+	ttl := metadata.MaxAge
+	if c.cfg.MinTTL > 0 && ttl < c.cfg.MinTTL {
+		ttl = c.cfg.MinTTL
+	}
+	if c.cfg.MaxTTL > 0 && ttl > c.cfg.MaxTTL {
+		ttl = c.cfg.MaxTTL
+	}
+	return ttl
+}
+
+// mergeMetadata updates cached metadata with a 304 response, which need not repeat every header
+func mergeMetadata(cached, notModified ResponseMetadata) ResponseMetadata {
+	if notModified.ETag == "" {
+		notModified.ETag = cached.ETag
+	}
+	if notModified.LastModified == "" {
+		notModified.LastModified = cached.LastModified
+	}
+	if !notModified.HasMaxAge {
+		notModified.MaxAge, notModified.HasMaxAge = cached.MaxAge, cached.HasMaxAge
+	}
+	return notModified
+}
+
+func (c *CachingJWKSRetriever) delete(key cacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+	delete(c.forced, key)
+}
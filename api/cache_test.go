@@ -0,0 +1,524 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type CountingJWKSRetriever struct {
+	JWKSRetriever
+	calls int32
+}
+
+func (cjr *CountingJWKSRetriever) RetrieveJWKS(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+	atomic.AddInt32(&cjr.calls, 1)
+	return cjr.JWKSRetriever.RetrieveJWKS(ctx, region, userPoolId, prev)
+}
+
+func (cjr *CountingJWKSRetriever) Calls() int {
+	return int(atomic.LoadInt32(&cjr.calls))
+}
+
+func TestCachingJWKSRetriever(t *testing.T) {
+	Convey("Given a caching retriever in front of a valid JWKS", t, func() {
+		now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+		cjr := &CountingJWKSRetriever{JWKSRetriever: MockJWKSRetriever{}}
+		cache := NewCachingJWKSRetriever(cjr, CacheConfig{TTL: time.Minute})
+		cache.now = func() time.Time { return now }
+
+		Convey("When the same user pool is requested twice within the TTL", func() {
+			first, err := cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+			So(err, ShouldBeNil)
+			second, err := cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+			So(err, ShouldBeNil)
+
+			Convey("Then Cognito is only asked once and the same keys are returned", func() {
+				So(cjr.Calls(), ShouldEqual, 1)
+				So(second.Keys, ShouldResemble, first.Keys)
+				So(second.Keys, ShouldContainKey, "j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8=")
+			})
+		})
+
+		Convey("When a different user pool or region is requested", func() {
+			cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+			cache.RetrieveKeys(ctx, "eu-west-2", "another-pool")
+			cache.RetrieveKeys(ctx, "eu-west-1", "pool")
+
+			Convey("Then each is fetched separately", func() {
+				So(cjr.Calls(), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When the TTL has passed", func() {
+			cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+			now = now.Add(time.Minute)
+			cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+
+			Convey("Then the keys are fetched again", func() {
+				So(cjr.Calls(), ShouldEqual, 2)
+			})
+		})
+	})
+
+	Convey("Given a caching retriever in front of a failing retriever", t, func() {
+		cjr := &CountingJWKSRetriever{JWKSRetriever: JWKSRetrieverError{}}
+		cache := NewCachingJWKSRetriever(cjr, CacheConfig{TTL: time.Minute})
+
+		Convey("Then errors are returned and not cached", func() {
+			_, err := cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+			So(err, ShouldEqual, ErrUserPoolNotFound)
+			_, err = cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+			So(err, ShouldEqual, ErrUserPoolNotFound)
+			So(cjr.Calls(), ShouldEqual, 2)
+		})
+	})
+
+	Convey("Given a caching retriever with caching disabled", t, func() {
+		cjr := &CountingJWKSRetriever{JWKSRetriever: MockJWKSRetriever{}}
+		cache := NewCachingJWKSRetriever(cjr, CacheConfig{})
+
+		Convey("Then every request is fetched", func() {
+			cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+			cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+			So(cjr.Calls(), ShouldEqual, 2)
+		})
+	})
+}
+
+func TestUserPoolIdHandlerWithCache(t *testing.T) {
+	Convey("Given a user pool id handler backed by a caching retriever", t, func() {
+		cjr := &CountingJWKSRetriever{JWKSRetriever: MockJWKSRetriever{}}
+		userPoolIdHandler := UserPoolIdHandler(ctx, NewCachingJWKSRetriever(cjr, CacheConfig{TTL: time.Minute}))
+
+		Convey("Then repeated requests are served from the cache with the same response", func() {
+			var bodies []string
+			for i := 0; i < 2; i++ {
+				resp := httptest.NewRecorder()
+				userPoolIdHandler.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil))
+				So(resp.Code, ShouldEqual, http.StatusOK)
+				bodies = append(bodies, resp.Body.String())
+			}
+			So(bodies[1], ShouldEqual, bodies[0])
+			So(cjr.Calls(), ShouldEqual, 1)
+		})
+	})
+}
+
+type BlockingJWKSRetriever struct {
+	JWKSRetriever
+	release chan struct{}
+}
+
+func (bjr BlockingJWKSRetriever) RetrieveJWKS(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+	<-bjr.release
+	return bjr.JWKSRetriever.RetrieveJWKS(ctx, region, userPoolId, prev)
+}
+
+func TestCachingJWKSRetrieverConcurrentMisses(t *testing.T) {
+	Convey("Given a caching retriever whose upstream request is slow", t, func() {
+		release := make(chan struct{})
+		retrieveConcurrently := func(cache *CachingJWKSRetriever, n int) []error {
+			errs := make([]error, n)
+			var wg sync.WaitGroup
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					_, errs[i] = cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+				}(i)
+			}
+			// give every goroutine the chance to join the in-flight request before it completes
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+			return errs
+		}
+
+		Convey("When a burst of requests arrives for the same cold user pool", func() {
+			cjr := &CountingJWKSRetriever{JWKSRetriever: BlockingJWKSRetriever{JWKSRetriever: MockJWKSRetriever{}, release: release}}
+			errs := retrieveConcurrently(NewCachingJWKSRetriever(cjr, CacheConfig{TTL: time.Minute}), 10)
+
+			Convey("Then they share a single upstream request and all succeed", func() {
+				So(cjr.Calls(), ShouldEqual, 1)
+				for _, err := range errs {
+					So(err, ShouldBeNil)
+				}
+			})
+		})
+
+		Convey("When the shared upstream request fails", func() {
+			cjr := &CountingJWKSRetriever{JWKSRetriever: BlockingJWKSRetriever{JWKSRetriever: JWKSRetrieverError{}, release: release}}
+			errs := retrieveConcurrently(NewCachingJWKSRetriever(cjr, CacheConfig{TTL: time.Minute}), 10)
+
+			Convey("Then every waiting request receives the same error", func() {
+				So(cjr.Calls(), ShouldEqual, 1)
+				for _, err := range errs {
+					So(err, ShouldEqual, ErrUserPoolNotFound)
+				}
+			})
+		})
+	})
+}
+
+type SwitchableJWKSRetriever struct {
+	mu      sync.Mutex
+	current JWKSRetriever
+}
+
+func (sjr *SwitchableJWKSRetriever) RetrieveJWKS(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+	sjr.mu.Lock()
+	current := sjr.current
+	sjr.mu.Unlock()
+	return current.RetrieveJWKS(ctx, region, userPoolId, prev)
+}
+
+func (sjr *SwitchableJWKSRetriever) SwitchTo(jr JWKSRetriever) {
+	sjr.mu.Lock()
+	defer sjr.mu.Unlock()
+	sjr.current = jr
+}
+
+func TestCachingJWKSRetrieverStaleKeys(t *testing.T) {
+	Convey("Given a caching retriever holding keys for a user pool", t, func() {
+		now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+		sjr := &SwitchableJWKSRetriever{current: MockJWKSRetriever{}}
+		cjr := &CountingJWKSRetriever{JWKSRetriever: sjr}
+		cache := NewCachingJWKSRetriever(cjr, CacheConfig{TTL: time.Minute, MaxStale: 10 * time.Minute})
+		cache.now = func() time.Time { return now }
+		fresh, err := cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+		So(err, ShouldBeNil)
+		So(fresh.Stale, ShouldBeFalse)
+		So(fresh.Age, ShouldEqual, 0)
+
+		Convey("When the keys are requested again within the TTL", func() {
+			now = now.Add(30 * time.Second)
+			keySet, err := cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+
+			Convey("Then they are fresh and report their age", func() {
+				So(err, ShouldBeNil)
+				So(keySet.Stale, ShouldBeFalse)
+				So(keySet.Age, ShouldEqual, 30*time.Second)
+			})
+		})
+
+		Convey("When the keys have expired and Cognito cannot be reached", func() {
+			sjr.SwitchTo(JWKSRetrieverHttpErr{})
+			now = now.Add(2 * time.Minute)
+			keySet, err := cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+
+			Convey("Then the last good keys are served as stale", func() {
+				So(err, ShouldBeNil)
+				So(keySet.Stale, ShouldBeTrue)
+				So(keySet.Age, ShouldEqual, 2*time.Minute)
+				So(keySet.Keys, ShouldResemble, fresh.Keys)
+			})
+
+			Convey("Then once past the maximum staleness the error is returned", func() {
+				now = now.Add(10 * time.Minute)
+				_, err := cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Http error occured whilst attempting to retrieve JWKS.")
+			})
+
+			Convey("Then once Cognito recovers fresh keys are served again", func() {
+				sjr.SwitchTo(MockJWKSRetriever{})
+				keySet, err := cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+				So(err, ShouldBeNil)
+				So(keySet.Stale, ShouldBeFalse)
+				So(keySet.Age, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the keys have expired and Cognito no longer knows the user pool", func() {
+			sjr.SwitchTo(JWKSRetrieverError{})
+			now = now.Add(2 * time.Minute)
+			_, err := cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+
+			Convey("Then the user pool is reported as not found and forgotten", func() {
+				So(err, ShouldEqual, ErrUserPoolNotFound)
+				_, cached := cache.get(cacheKey{region: "eu-west-2", userPoolId: "pool"})
+				So(cached, ShouldBeFalse)
+			})
+		})
+
+		Convey("When the refresher runs before the keys are due to expire", func() {
+			now = now.Add(20 * time.Second)
+			cache.refresh(ctx, 30*time.Second)
+
+			Convey("Then nothing is fetched", func() {
+				So(cjr.Calls(), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the refresher runs and the keys would expire before its next run", func() {
+			now = now.Add(40 * time.Second)
+			cache.refresh(ctx, 30*time.Second)
+
+			Convey("Then the keys are fetched again and their age reset", func() {
+				So(cjr.Calls(), ShouldEqual, 2)
+				keySet, err := cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+				So(err, ShouldBeNil)
+				So(keySet.Age, ShouldEqual, 0)
+				So(cjr.Calls(), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When the refresher cannot reach Cognito", func() {
+			sjr.SwitchTo(JWKSRetrieverHttpErr{})
+			now = now.Add(40 * time.Second)
+			cache.refresh(ctx, 30*time.Second)
+
+			Convey("Then the existing keys are kept", func() {
+				now = now.Add(time.Minute)
+				keySet, err := cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+				So(err, ShouldBeNil)
+				So(keySet.Stale, ShouldBeTrue)
+			})
+		})
+
+		Convey("When the refresher runs after the keys are too old to be served", func() {
+			now = now.Add(11 * time.Minute)
+			cache.refresh(ctx, 30*time.Second)
+
+			Convey("Then they are evicted rather than refreshed", func() {
+				So(cjr.Calls(), ShouldEqual, 1)
+				_, cached := cache.get(cacheKey{region: "eu-west-2", userPoolId: "pool"})
+				So(cached, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestCachingJWKSRetrieverRefresher(t *testing.T) {
+	Convey("Given a caching retriever with short lived keys", t, func() {
+		var started, returned int32
+		refreshed := make(chan struct{})
+		jr := FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+			defer atomic.AddInt32(&returned, 1)
+			if atomic.AddInt32(&started, 1) == 3 {
+				close(refreshed)
+			}
+			return MockJWKSRetriever{}.RetrieveJWKS(ctx, region, userPoolId, prev)
+		})
+		cache := NewCachingJWKSRetriever(jr, CacheConfig{TTL: 5 * time.Millisecond, MaxStale: time.Minute})
+		cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+
+		Convey("When the refresher is started", func() {
+			cache.StartRefresher(time.Millisecond)
+			<-refreshed
+
+			Convey("Then the keys are refreshed in the background until it is stopped, which waits for refreshes in flight", func() {
+				cache.StopRefresher()
+				So(atomic.LoadInt32(&started), ShouldBeGreaterThanOrEqualTo, 3)
+				So(atomic.LoadInt32(&returned), ShouldEqual, atomic.LoadInt32(&started))
+			})
+		})
+
+		Convey("Then stopping a refresher that was never started does nothing", func() {
+			So(cache.StopRefresher, ShouldNotPanic)
+		})
+	})
+}
+
+func TestUserPoolIdHandlerFreshness(t *testing.T) {
+	Convey("Given a user pool id handler backed by a cache holding keys for a user pool", t, func() {
+		now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+		sjr := &SwitchableJWKSRetriever{current: MockJWKSRetriever{}}
+		cache := NewCachingJWKSRetriever(sjr, CacheConfig{TTL: time.Minute, MaxStale: time.Hour})
+		cache.now = func() time.Time { return now }
+		userPoolIdHandler := UserPoolIdHandler(ctx, cache)
+		serve := func() *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			userPoolIdHandler.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil))
+			return resp
+		}
+		serve()
+
+		Convey("When fresh keys are served, their age is reported and they are not marked stale", func() {
+			now = now.Add(45 * time.Second)
+			resp := serve()
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get("Age"), ShouldEqual, "45")
+			So(resp.Header().Get("Warning"), ShouldEqual, "")
+		})
+
+		Convey("When stale keys are served, their age is reported and they are marked stale", func() {
+			sjr.SwitchTo(JWKSRetrieverHttpErr{})
+			now = now.Add(5 * time.Minute)
+			resp := serve()
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get("Age"), ShouldEqual, "300")
+			So(resp.Header().Get("Warning"), ShouldEqual, `110 - "Response is Stale"`)
+		})
+	})
+}
+
+type ConditionalJWKSRetriever struct {
+	etag     string
+	metadata ResponseMetadata
+	prevs    []ResponseMetadata
+}
+
+func (cjr *ConditionalJWKSRetriever) RetrieveJWKS(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+	cjr.prevs = append(cjr.prevs, prev)
+	if prev.ETag != "" && prev.ETag == cjr.etag {
+		return &JWKSResponse{Body: io.NopCloser(strings.NewReader("")), StatusCode: http.StatusNotModified, Metadata: ResponseMetadata{ETag: cjr.etag}}, nil
+	}
+	resp, err := MockJWKSRetriever{}.RetrieveJWKS(ctx, region, userPoolId, prev)
+	resp.Metadata = cjr.metadata
+	resp.Metadata.ETag = cjr.etag
+	return resp, err
+}
+
+func TestCachingJWKSRetrieverConditionalRequests(t *testing.T) {
+	Convey("Given a caching retriever in front of a JWKS with an ETag", t, func() {
+		now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+		cjr := &ConditionalJWKSRetriever{etag: `"v1"`, metadata: ResponseMetadata{MaxAge: time.Minute, HasMaxAge: true}}
+		cache := NewCachingJWKSRetriever(cjr, CacheConfig{TTL: time.Hour, MaxStale: time.Hour})
+		cache.now = func() time.Time { return now }
+		first, err := cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+		So(err, ShouldBeNil)
+
+		Convey("Then the first request is unconditional", func() {
+			So(cjr.prevs, ShouldHaveLength, 1)
+			So(cjr.prevs[0], ShouldResemble, ResponseMetadata{})
+		})
+
+		Convey("When the advertised max-age has passed and the JWKS is unchanged", func() {
+			now = now.Add(2 * time.Minute)
+			keySet, err := cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+
+			Convey("Then the request is conditional on the ETag and the cached keys are kept as fresh", func() {
+				So(err, ShouldBeNil)
+				So(cjr.prevs, ShouldHaveLength, 2)
+				So(cjr.prevs[1].ETag, ShouldEqual, `"v1"`)
+				So(keySet.Keys, ShouldResemble, first.Keys)
+				So(keySet.Stale, ShouldBeFalse)
+				So(keySet.Age, ShouldEqual, 0)
+			})
+
+			Convey("Then the max-age of the original response still applies", func() {
+				now = now.Add(30 * time.Second)
+				cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+				So(cjr.prevs, ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("When the advertised max-age has passed and the JWKS has changed", func() {
+			cjr.etag = `"v2"`
+			now = now.Add(2 * time.Minute)
+			_, err := cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+
+			Convey("Then the new JWKS and its ETag are cached", func() {
+				So(err, ShouldBeNil)
+				entry, _ := cache.get(cacheKey{region: "eu-west-2", userPoolId: "pool"})
+				So(entry.metadata.ETag, ShouldEqual, `"v2"`)
+			})
+		})
+	})
+}
+
+func TestCachingJWKSRetrieverTTL(t *testing.T) {
+	Convey("Given a caching retriever with bounds on the advertised lifetime", t, func() {
+		cache := NewCachingJWKSRetriever(nil, CacheConfig{TTL: 5 * time.Minute, MinTTL: time.Minute, MaxTTL: time.Hour})
+
+		Convey("Then a response without a max-age is cached for the configured TTL", func() {
+			So(cache.ttl(ResponseMetadata{}), ShouldEqual, 5*time.Minute)
+		})
+
+		Convey("Then a max-age within the bounds is followed", func() {
+			So(cache.ttl(ResponseMetadata{MaxAge: 10 * time.Minute, HasMaxAge: true}), ShouldEqual, 10*time.Minute)
+		})
+
+		Convey("Then a max-age outside the bounds is clamped to them", func() {
+			So(cache.ttl(ResponseMetadata{MaxAge: 0, HasMaxAge: true}), ShouldEqual, time.Minute)
+			So(cache.ttl(ResponseMetadata{MaxAge: 24 * time.Hour, HasMaxAge: true}), ShouldEqual, time.Hour)
+		})
+	})
+
+	Convey("Given a caching retriever without bounds, check the advertised max-age is followed exactly", t, func() {
+		cache := NewCachingJWKSRetriever(nil, CacheConfig{TTL: 5 * time.Minute})
+		So(cache.ttl(ResponseMetadata{MaxAge: 0, HasMaxAge: true}), ShouldEqual, 0)
+		So(cache.ttl(ResponseMetadata{MaxAge: 24 * time.Hour, HasMaxAge: true}), ShouldEqual, 24*time.Hour)
+	})
+}
+
+type ContextJWKSRetriever struct {
+	cancelled chan struct{}
+}
+
+func (cjr ContextJWKSRetriever) RetrieveJWKS(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+	<-ctx.Done()
+	close(cjr.cancelled)
+	return nil, ctx.Err()
+}
+
+func TestUserPoolIdHandlerClientDisconnects(t *testing.T) {
+	Convey("Given a user pool id handler waiting on Cognito", t, func() {
+		cjr := ContextJWKSRetriever{cancelled: make(chan struct{})}
+		userPoolIdHandler := UserPoolIdHandler(ctx, NewCachingJWKSRetriever(cjr, CacheConfig{TTL: time.Minute}))
+		reqCtx, disconnect := context.WithCancel(ctx)
+		req := httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil).WithContext(reqCtx)
+		done := make(chan struct{})
+		go func() {
+			userPoolIdHandler.ServeHTTP(httptest.NewRecorder(), req)
+			close(done)
+		}()
+
+		Convey("When the client disconnects, check the upstream request is cancelled", func() {
+			disconnect()
+			select {
+			case <-cjr.cancelled:
+			case <-time.After(time.Second):
+				So("upstream request was not cancelled", ShouldBeEmpty)
+			}
+			<-done
+		})
+	})
+}
+
+func TestCachingJWKSRetrieverRefreshKeys(t *testing.T) {
+	Convey("Given a caching retriever with forced refreshes disabled, check refreshing keys is skipped", t, func() {
+		cjr := &CountingJWKSRetriever{JWKSRetriever: MockJWKSRetriever{}}
+		cache := NewCachingJWKSRetriever(cjr, CacheConfig{TTL: time.Minute})
+		cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+
+		_, err := cache.RefreshKeys(ctx, "eu-west-2", "pool")
+		So(err, ShouldEqual, ErrRefreshSkipped)
+		So(cjr.Calls(), ShouldEqual, 1)
+	})
+
+	Convey("Given a caching retriever with caching disabled, check refreshing keys is skipped", t, func() {
+		cjr := &CountingJWKSRetriever{JWKSRetriever: MockJWKSRetriever{}}
+		cache := NewCachingJWKSRetriever(cjr, CacheConfig{KidRefreshInterval: time.Second})
+
+		_, err := cache.RefreshKeys(ctx, "eu-west-2", "pool")
+		So(err, ShouldEqual, ErrRefreshSkipped)
+		So(cjr.Calls(), ShouldEqual, 0)
+	})
+
+	Convey("Given a caching retriever holding keys older than the refresh interval, check they are refreshed once", t, func() {
+		now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+		cjr := &CountingJWKSRetriever{JWKSRetriever: MockJWKSRetriever{}}
+		cache := NewCachingJWKSRetriever(cjr, CacheConfig{TTL: time.Hour, KidRefreshInterval: time.Minute})
+		cache.now = func() time.Time { return now }
+		cache.RetrieveKeys(ctx, "eu-west-2", "pool")
+		now = now.Add(2 * time.Minute)
+
+		keySet, err := cache.RefreshKeys(ctx, "eu-west-2", "pool")
+		So(err, ShouldBeNil)
+		So(keySet.Keys, ShouldContainKey, "j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8=")
+		_, err = cache.RefreshKeys(ctx, "eu-west-2", "pool")
+		So(err, ShouldEqual, ErrRefreshSkipped)
+		So(cjr.Calls(), ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// ErrUserPoolNotFound is returned when Cognito does not recognise the user pool in the given region
+var ErrUserPoolNotFound = errors.New("user pool not found")
+
+// ErrKeyConversion is returned when a retrieved JWKS cannot be converted into public keys
+var ErrKeyConversion = errors.New("failed to convert JWKS into public keys")
+
+// Error codes returned in ErrorResponse
+const (
+	CodeUserPoolNotFound          = "UserPoolNotFound"
+	CodeKeyNotFound               = "KeyNotFound"
+	CodeUpstreamError             = "UpstreamError"
+	CodeUpstreamDNSFailure        = "UpstreamDNSFailure"
+	CodeUpstreamConnectionRefused = "UpstreamConnectionRefused"
+	CodeUpstreamTimeout           = "UpstreamTimeout"
+	CodeUpstreamTLSFailure        = "UpstreamTLSFailure"
+	CodeUpstreamUnexpectedStatus  = "UpstreamUnexpectedStatus"
+	CodeUpstreamInvalidJSON       = "UpstreamInvalidJSON"
+	CodeUpstreamInvalidJWKS       = "UpstreamInvalidJWKS"
+	CodeUpstreamDuplicateKid      = "UpstreamDuplicateKid"
+	CodeKeyPolicyViolation        = "KeyPolicyViolation"
+	CodeUpstreamBodyTooLarge      = "UpstreamBodyTooLarge"
+	CodeUpstreamUnavailable       = "UpstreamUnavailable"
+	CodeKeyConversionFailed       = "KeyConversionFailed"
+	CodeInvalidFormat             = "InvalidFormat"
+	CodeInvalidRequest            = "InvalidRequest"
+	CodeNotAcceptable             = "NotAcceptable"
+	CodeInternalServerError       = "InternalServerError"
+)
+
+// UpstreamErrorKind identifies why a JWKS could not be retrieved from Cognito
+type UpstreamErrorKind int
+
+const (
+	// UpstreamRequestFailed is a failed request that fits none of the other kinds
+	UpstreamRequestFailed UpstreamErrorKind = iota
+	// UpstreamDNSFailure is a failure to resolve Cognito's host name
+	UpstreamDNSFailure
+	// UpstreamConnectionRefused is Cognito's host refusing the connection
+	UpstreamConnectionRefused
+	// UpstreamTimeout is the request, or reading its body, taking longer than allowed
+	UpstreamTimeout
+	// UpstreamTLSFailure is a failed TLS handshake, including Cognito's certificate not being trusted
+	UpstreamTLSFailure
+	// UpstreamUnexpectedStatus is Cognito responding with a status other than 200 or 404
+	UpstreamUnexpectedStatus
+	// UpstreamInvalidJSON is Cognito responding with a body that is not JSON
+	UpstreamInvalidJSON
+	// UpstreamBodyTooLarge is Cognito responding with a body larger than the configured maximum
+	UpstreamBodyTooLarge
+	// UpstreamInvalidJWKS is Cognito responding with JSON that is not a well formed JWKS
+	UpstreamInvalidJWKS
+)
+
+func (k UpstreamErrorKind) String() string {
+	switch k {
+	case UpstreamDNSFailure:
+		return "DNS lookup for AWS Cognito failed"
+	case UpstreamConnectionRefused:
+		return "connection to AWS Cognito refused"
+	case UpstreamTimeout:
+		return "request to AWS Cognito timed out"
+	case UpstreamTLSFailure:
+		return "TLS handshake with AWS Cognito failed"
+	case UpstreamUnexpectedStatus:
+		return "unexpected response from AWS Cognito"
+	case UpstreamInvalidJSON:
+		return "response from AWS Cognito is not JSON"
+	case UpstreamBodyTooLarge:
+		return "response from AWS Cognito is too large"
+	case UpstreamInvalidJWKS:
+		return "response from AWS Cognito is not a valid JWKS"
+	default:
+		return "an error occurred whilst requesting JWKS from AWS Cognito"
+	}
+}
+
+func (k UpstreamErrorKind) code() string {
+	switch k {
+	case UpstreamDNSFailure:
+		return CodeUpstreamDNSFailure
+	case UpstreamConnectionRefused:
+		return CodeUpstreamConnectionRefused
+	case UpstreamTimeout:
+		return CodeUpstreamTimeout
+	case UpstreamTLSFailure:
+		return CodeUpstreamTLSFailure
+	case UpstreamUnexpectedStatus:
+		return CodeUpstreamUnexpectedStatus
+	case UpstreamInvalidJSON:
+		return CodeUpstreamInvalidJSON
+	case UpstreamBodyTooLarge:
+		return CodeUpstreamBodyTooLarge
+	case UpstreamInvalidJWKS:
+		return CodeUpstreamInvalidJWKS
+	default:
+		return CodeUpstreamError
+	}
+}
+
+// UpstreamError is returned when a JWKS could not be retrieved from Cognito, saying why
+type UpstreamError struct {
+	Kind UpstreamErrorKind
+	Err  error
+}
+
+func (e *UpstreamError) Error() string {
+	return e.Kind.String() + ": " + e.Err.Error()
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// newUpstreamError classifies an error returned by the HTTP client when requesting or reading a JWKS
+func newUpstreamError(err error) *UpstreamError {
+	var dnsErr *net.DNSError
+	var unknownAuthority x509.UnknownAuthorityError
+	var certificateInvalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	var recordHeader tls.RecordHeaderError
+	switch {
+	case isTimeout(err):
+		return &UpstreamError{Kind: UpstreamTimeout, Err: err}
+	case errors.As(err, &dnsErr):
+		return &UpstreamError{Kind: UpstreamDNSFailure, Err: err}
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return &UpstreamError{Kind: UpstreamConnectionRefused, Err: err}
+	case errors.As(err, &unknownAuthority), errors.As(err, &certificateInvalid), errors.As(err, &hostname),
+		errors.As(err, &recordHeader), strings.Contains(err.Error(), "tls: "):
+		return &UpstreamError{Kind: UpstreamTLSFailure, Err: err}
+	default:
+		return &UpstreamError{Kind: UpstreamRequestFailed, Err: err}
+	}
+}
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	Errors []Error `json:"errors"`
+}
+
+// Error describes a single error in an ErrorResponse
+type Error struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// writeRetrievalError writes the error response for a failure to retrieve a user pool's keys
+func writeRetrievalError(w http.ResponseWriter, err error, region, userPoolId string) {
+	log.Println(err.Error())
+	var upstreamErr *UpstreamError
+	var collision *KidCollisionError
+	var violation *PolicyViolationError
+	switch {
+	case err == ErrUserPoolNotFound:
+		writeErrorResponse(w, http.StatusNotFound, CodeUserPoolNotFound, fmt.Sprintf("User pool %s in region %s not found. Try changing the region or user pool ID.", userPoolId, region))
+	case err == ErrCircuitOpen:
+		writeErrorResponse(w, http.StatusServiceUnavailable, CodeUpstreamUnavailable, "AWS Cognito is currently unavailable. Try again later.")
+	case err == ErrKeyConversion:
+		writeErrorResponse(w, http.StatusInternalServerError, CodeKeyConversionFailed, "Failed to retrieve public key")
+	case errors.As(err, &collision):
+		writeErrorResponse(w, http.StatusBadGateway, CodeUpstreamDuplicateKid, "The JWKS from AWS Cognito has different keys with the same kid: "+strings.Join(collision.Kids, ", "))
+	case errors.As(err, &violation):
+		writeErrorResponse(w, http.StatusBadGateway, CodeKeyPolicyViolation, "The JWKS from AWS Cognito has keys that fail the key policy: "+violation.reasons())
+	case errors.As(err, &upstreamErr) && upstreamErr.Kind == UpstreamTimeout:
+		writeErrorResponse(w, http.StatusGatewayTimeout, CodeUpstreamTimeout, upstreamErr.Error())
+	case errors.As(err, &upstreamErr):
+		writeErrorResponse(w, http.StatusBadGateway, upstreamErr.Kind.code(), upstreamErr.Error())
+	case isTimeout(err):
+		writeErrorResponse(w, http.StatusGatewayTimeout, CodeUpstreamTimeout, "Timed out requesting JWKS from AWS Cognito")
+	default:
+		writeErrorResponse(w, http.StatusBadGateway, CodeUpstreamError, err.Error())
+	}
+}
+
+// writeFormatError writes the error response for a request for keys in a format that is not served
+func writeFormatError(w http.ResponseWriter, err error) {
+	names := make([]string, len(formats))
+	contentTypes := make([]string, len(formats))
+	for i, f := range formats {
+		names[i], contentTypes[i] = f.Name, f.ContentType
+	}
+	if err == ErrUnknownFormat {
+		writeErrorResponse(w, http.StatusBadRequest, CodeInvalidFormat, "Unknown format. Must be one of: "+strings.Join(names, ", "))
+		return
+	}
+	writeErrorResponse(w, http.StatusNotAcceptable, CodeNotAcceptable, "None of the accepted media types are served. Must be one of: "+strings.Join(contentTypes, ", "))
+}
+
+// writeErrorResponse writes an ErrorResponse holding a single error
+func writeErrorResponse(w http.ResponseWriter, status int, code, description string) {
+	writeJSONResponse(w, status, ErrorResponse{Errors: []Error{{Code: code, Description: description}}})
+}
+
+// writeJSONResponse writes the body as JSON with the given status
+func writeJSONResponse(w http.ResponseWriter, status int, body interface{}) {
+	jsonResponse, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("Failed to convert Response object into json.\nError:%s\n", err.Error())
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"errors":[{"code":"InternalServerError","description":"Failed to write response"}]}`))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(jsonResponse)
+}
+
+// isTimeout reports whether an error was caused by a request timing out
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
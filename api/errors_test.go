@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewUpstreamError(t *testing.T) {
+	Convey("Given errors returned by the HTTP client, check they are classified by cause", t, func() {
+		urlErr := func(err error) error {
+			return &url.Error{Op: "Get", URL: "https://cognito-idp.eu-west-2.amazonaws.com/pool/.well-known/jwks.json", Err: err}
+		}
+		tests := []struct {
+			err  error
+			kind UpstreamErrorKind
+		}{
+			{urlErr(&net.DNSError{Err: "no such host", Name: "cognito-idp.eu-west-2.amazonaws.com", IsNotFound: true}), UpstreamDNSFailure},
+			{urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), UpstreamConnectionRefused},
+			{urlErr(context.DeadlineExceeded), UpstreamTimeout},
+			{urlErr(&net.DNSError{Err: "i/o timeout", Name: "cognito-idp.eu-west-2.amazonaws.com", IsTimeout: true}), UpstreamTimeout},
+			{urlErr(x509.UnknownAuthorityError{}), UpstreamTLSFailure},
+			{urlErr(x509.HostnameError{Certificate: &x509.Certificate{}, Host: "cognito-idp.eu-west-2.amazonaws.com"}), UpstreamTLSFailure},
+			{urlErr(tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}), UpstreamTLSFailure},
+			{urlErr(errors.New("remote error: tls: handshake failure")), UpstreamTLSFailure},
+			{urlErr(errors.New("EOF")), UpstreamRequestFailed},
+		}
+		for _, test := range tests {
+			upstreamErr := newUpstreamError(test.err)
+			So(upstreamErr.Kind, ShouldEqual, test.kind)
+			So(errors.Unwrap(upstreamErr), ShouldEqual, test.err)
+		}
+	})
+}
+
+func TestCognitoJWKSRetrieverTransportErrors(t *testing.T) {
+	Convey("Given a stand-in for Cognito that refuses connections, check the error says so", t, func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		addr := listener.Addr().String()
+		listener.Close()
+		jr := NewCognitoJWKSRetriever(http.DefaultClient, "http://"+addr+"/{userPoolId}/.well-known/jwks.json")
+
+		resp, err := jr.RetrieveJWKS(ctx, "eu-west-2", "pool", ResponseMetadata{})
+
+		So(resp, ShouldBeNil)
+		var upstreamErr *UpstreamError
+		So(errors.As(err, &upstreamErr), ShouldBeTrue)
+		So(upstreamErr.Kind, ShouldEqual, UpstreamConnectionRefused)
+	})
+
+	Convey("Given a stand-in for Cognito whose certificate is not trusted, check the error says the TLS handshake failed", t, func() {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+		defer server.Close()
+		jr := NewCognitoJWKSRetriever(http.DefaultClient, server.URL+"/{userPoolId}/.well-known/jwks.json")
+
+		resp, err := jr.RetrieveJWKS(ctx, "eu-west-2", "pool", ResponseMetadata{})
+
+		So(resp, ShouldBeNil)
+		var upstreamErr *UpstreamError
+		So(errors.As(err, &upstreamErr), ShouldBeTrue)
+		So(upstreamErr.Kind, ShouldEqual, UpstreamTLSFailure)
+	})
+}
+
+func TestWriteRetrievalError(t *testing.T) {
+	Convey("Given the errors that can occur retrieving keys, check each gets its own status and code", t, func() {
+		tests := []struct {
+			err    error
+			status int
+			code   string
+		}{
+			{ErrUserPoolNotFound, http.StatusNotFound, CodeUserPoolNotFound},
+			{ErrCircuitOpen, http.StatusServiceUnavailable, CodeUpstreamUnavailable},
+			{ErrKeyConversion, http.StatusInternalServerError, CodeKeyConversionFailed},
+			{&KidCollisionError{Kids: []string{"kid"}}, http.StatusBadGateway, CodeUpstreamDuplicateKid},
+			{&PolicyViolationError{KeyErrors: []KeyError{{Kid: "kid", Reason: "use \"enc\" is not sig"}}}, http.StatusBadGateway, CodeKeyPolicyViolation},
+			{&UpstreamError{Kind: UpstreamDNSFailure, Err: errors.New("no such host")}, http.StatusBadGateway, CodeUpstreamDNSFailure},
+			{&UpstreamError{Kind: UpstreamConnectionRefused, Err: errors.New("connection refused")}, http.StatusBadGateway, CodeUpstreamConnectionRefused},
+			{&UpstreamError{Kind: UpstreamTimeout, Err: context.DeadlineExceeded}, http.StatusGatewayTimeout, CodeUpstreamTimeout},
+			{&UpstreamError{Kind: UpstreamTLSFailure, Err: x509.UnknownAuthorityError{}}, http.StatusBadGateway, CodeUpstreamTLSFailure},
+			{&UpstreamError{Kind: UpstreamUnexpectedStatus, Err: errors.New("status 500")}, http.StatusBadGateway, CodeUpstreamUnexpectedStatus},
+			{&UpstreamError{Kind: UpstreamInvalidJSON, Err: errors.New("invalid character '<'")}, http.StatusBadGateway, CodeUpstreamInvalidJSON},
+			{&UpstreamError{Kind: UpstreamInvalidJWKS, Err: errors.New("missing required member keys")}, http.StatusBadGateway, CodeUpstreamInvalidJWKS},
+			{&UpstreamError{Kind: UpstreamBodyTooLarge, Err: errors.New("body exceeds 1048576 bytes")}, http.StatusBadGateway, CodeUpstreamBodyTooLarge},
+			{&UpstreamError{Kind: UpstreamRequestFailed, Err: errors.New("EOF")}, http.StatusBadGateway, CodeUpstreamError},
+			{errors.New("something else"), http.StatusBadGateway, CodeUpstreamError},
+		}
+		for _, test := range tests {
+			resp := httptest.NewRecorder()
+
+			writeRetrievalError(resp, test.err, "eu-west-2", "pool")
+
+			So(resp.Code, ShouldEqual, test.status)
+			So(resp.Header().Get("Content-Type"), ShouldEqual, "application/json; charset=utf-8")
+			So(resp.Body.String(), ShouldContainSubstring, `"code":"`+test.code+`"`)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup collapses concurrent fetches of the same key into a single call, whose result, error included,
+// is shared by every caller waiting on it. Unlike singleflight, the call runs with its own context, which is
+// only cancelled once every caller has given up, so one client disconnecting does not fail the others.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+	running sync.WaitGroup
+}
+
+type flight struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	keySet  KeySet
+	err     error
+}
+
+// do runs fn for the key, unless a call for it is already in flight, and waits for its result or for ctx to end
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (KeySet, error)) (KeySet, error) {
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	f, ok := g.flights[key]
+	if !ok {
+		flightCtx, cancel := context.WithCancel(context.Background())
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		g.flights[key] = f
+		g.running.Add(1)
+		go func() {
+			defer g.running.Done()
+			f.keySet, f.err = fn(flightCtx)
+			cancel()
+			g.forget(key, f)
+			close(f.done)
+		}()
+	}
+	f.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.keySet, f.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			f.cancel()
+			g.forgetLocked(key, f)
+		}
+		g.mu.Unlock()
+		return KeySet{}, ctx.Err()
+	}
+}
+
+// wait waits for every call in flight, including those whose callers have all given up, to return
+func (g *flightGroup) wait() {
+	g.running.Wait()
+}
+
+// forget stops new callers joining a flight, leaving any newer flight for the same key in place
+func (g *flightGroup) forget(key string, f *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.forgetLocked(key, f)
+}
+
+func (g *flightGroup) forgetLocked(key string, f *flight) {
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFlightGroup(t *testing.T) {
+	Convey("Given a flight group and a call that blocks until released or cancelled", t, func() {
+		var g flightGroup
+		release := make(chan struct{})
+		cancelled := make(chan struct{})
+		var calls int
+		var mu sync.Mutex
+		fn := func(ctx context.Context) (KeySet, error) {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+			select {
+			case <-release:
+				return KeySet{Keys: map[string]PublicKey{"kid": {Kty: "RSA", DER: "key"}}}, nil
+			case <-ctx.Done():
+				close(cancelled)
+				return KeySet{}, ctx.Err()
+			}
+		}
+
+		Convey("When several callers ask for the same key and the call completes", func() {
+			var wg sync.WaitGroup
+			results := make([]KeySet, 5)
+			for i := range results {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					results[i], _ = g.do(context.Background(), "pool", fn)
+				}(i)
+			}
+			time.Sleep(20 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			Convey("Then the call is made once and every caller gets its result", func() {
+				So(calls, ShouldEqual, 1)
+				for _, result := range results {
+					So(result, ShouldResemble, KeySet{Keys: map[string]PublicKey{"kid": {Kty: "RSA", DER: "key"}}})
+				}
+			})
+		})
+
+		Convey("When one of two callers gives up", func() {
+			giveUpCtx, giveUp := context.WithCancel(context.Background())
+			var wg sync.WaitGroup
+			var stayed KeySet
+			var leftErr error
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				stayed, _ = g.do(context.Background(), "pool", fn)
+			}()
+			go func() {
+				defer wg.Done()
+				_, leftErr = g.do(giveUpCtx, "pool", fn)
+			}()
+			time.Sleep(20 * time.Millisecond)
+			giveUp()
+			time.Sleep(20 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			Convey("Then it gets its context's error and the call carries on for the other", func() {
+				So(leftErr, ShouldEqual, context.Canceled)
+				So(stayed, ShouldResemble, KeySet{Keys: map[string]PublicKey{"kid": {Kty: "RSA", DER: "key"}}})
+				So(calls, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When every caller gives up", func() {
+			giveUpCtx, giveUp := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				_, err := g.do(giveUpCtx, "pool", fn)
+				done <- err
+			}()
+			time.Sleep(20 * time.Millisecond)
+			giveUp()
+
+			Convey("Then the call itself is cancelled", func() {
+				So(<-done, ShouldEqual, context.Canceled)
+				select {
+				case <-cancelled:
+				case <-time.After(time.Second):
+					So(errors.New("call was not cancelled"), ShouldBeNil)
+				}
+			})
+
+			Convey("Then waiting for calls in flight returns only once the cancelled call has", func() {
+				<-done
+				g.wait()
+				select {
+				case <-cancelled:
+				default:
+					So(errors.New("wait returned before the call"), ShouldBeNil)
+				}
+			})
+
+			Convey("Then a later caller starts a new call rather than joining the cancelled one", func() {
+				<-done
+				<-cancelled
+				close(release)
+				result, err := g.do(context.Background(), "pool", fn)
+				So(err, ShouldBeNil)
+				So(result, ShouldResemble, KeySet{Keys: map[string]PublicKey{"kid": {Kty: "RSA", DER: "key"}}})
+				So(calls, ShouldEqual, 2)
+			})
+		})
+	})
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"bytes"
+	"crypto/x509"
+	b64 "encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Format is a representation the keys of a user pool can be served in
+type Format struct {
+	// Name is the value of the format query parameter that selects the format
+	Name string
+	// ContentType is the media type of the format, which selects it when given in the Accept header
+	ContentType string
+	render      func(keys []PublicKey) ([]byte, error)
+}
+
+// Formats the keys of a user pool can be served in, the first being the default
+var (
+	FormatDER = Format{Name: "der", ContentType: "application/json", render: renderDER}
+	FormatPEM = Format{Name: "pem", ContentType: "application/x-pem-file", render: renderPEM}
+	FormatJWK = Format{Name: "jwk", ContentType: "application/jwk-set+json", render: renderJWK}
+	FormatSSH = Format{Name: "ssh", ContentType: "text/plain", render: renderSSH}
+
+	formats = []Format{FormatDER, FormatPEM, FormatJWK, FormatSSH}
+)
+
+// ErrUnknownFormat is returned when the format query parameter names no Format
+var ErrUnknownFormat = errors.New("unknown format")
+
+// ErrNotAcceptable is returned when the Accept header allows none of the Formats
+var ErrNotAcceptable = errors.New("not acceptable")
+
+// negotiateFormat selects the format of a response: the one named by the format query parameter if there is one,
+// otherwise the one most preferred by the Accept header, or FormatDER if the request has no preference
+func negotiateFormat(req *http.Request) (Format, error) {
+	if name := req.URL.Query().Get("format"); name != "" {
+		for _, f := range formats {
+			if f.Name == name {
+				return f, nil
+			}
+		}
+		return Format{}, ErrUnknownFormat
+	}
+	accept := req.Header.Get("Accept")
+	if accept == "" {
+		return FormatDER, nil
+	}
+	for _, mediaType := range acceptedMediaTypes(accept) {
+		if mediaType == "*/*" || mediaType == "application/*" {
+			return FormatDER, nil
+		}
+		if mediaType == "text/*" {
+			return FormatSSH, nil
+		}
+		for _, f := range formats {
+			if f.ContentType == mediaType {
+				return f, nil
+			}
+		}
+	}
+	return Format{}, ErrNotAcceptable
+}
+
+// acceptedMediaTypes returns the media types of an Accept header, most preferred first, leaving out any with q=0
+func acceptedMediaTypes(accept string) []string {
+	type acceptedMediaType struct {
+		mediaType string
+		q         float64
+	}
+	var accepted []acceptedMediaType
+	for _, value := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			accepted = append(accepted, acceptedMediaType{mediaType: mediaType, q: q})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+	mediaTypes := make([]string, len(accepted))
+	for i, a := range accepted {
+		mediaTypes[i] = a.mediaType
+	}
+	return mediaTypes
+}
+
+// writeKeys writes the keys in the given format
+func writeKeys(w http.ResponseWriter, f Format, keys map[string]PublicKey) {
+	body, err := f.render(sortedKeys(keys))
+	if err != nil {
+		log.Printf("Failed to render keys as %s.\nError:%s\n", f.Name, err.Error())
+		writeErrorResponse(w, http.StatusInternalServerError, CodeInternalServerError, "Failed to write response")
+		return
+	}
+	w.Header().Set("Content-Type", f.ContentType+"; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// sortedKeys returns the keys in order of kid, so that every format lists them in the same order
+func sortedKeys(keys map[string]PublicKey) []PublicKey {
+	kids := make([]string, 0, len(keys))
+	for kid := range keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	sorted := make([]PublicKey, len(kids))
+	for i, kid := range kids {
+		sorted[i] = keys[kid]
+	}
+	return sorted
+}
+
+// renderDER renders the v1 response, a JSON object of kid to base64 encoded PKIX DER
+func renderDER(keys []PublicKey) ([]byte, error) {
+	response := make(map[string]string, len(keys))
+	for _, key := range keys {
+		response[key.JWK.Kid] = key.DER
+	}
+	return json.Marshal(response)
+}
+
+// renderPEM renders a PEM PUBLIC KEY block per key, each preceded by a line of explanatory text giving its kid,
+// which RFC 7468 parsers ignore, and followed by a CERTIFICATE block per certificate of its x5c chain
+func renderPEM(keys []PublicKey) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, key := range keys {
+		der, err := b64.StdEncoding.DecodeString(key.DER)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "kid: %s\n", key.JWK.Kid)
+		if err := pem.Encode(&buf, &pem.Block{Type: "PUBLIC KEY", Bytes: der}); err != nil {
+			return nil, err
+		}
+		buf.Write(encodeCertificates(key.Certificates))
+	}
+	return buf.Bytes(), nil
+}
+
+// renderJWK renders the JWK set the keys were converted from, passing each JWK through as retrieved
+func renderJWK(keys []PublicKey) ([]byte, error) {
+	jwks := JWKS{Keys: make([]JsonKey, len(keys))}
+	for i, key := range keys {
+		jwks.Keys[i] = key.JWK
+	}
+	return json.Marshal(jwks)
+}
+
+// renderSSH renders an OpenSSH authorized_keys line per key, commented with its kid
+func renderSSH(keys []PublicKey) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, key := range keys {
+		der, err := b64.StdEncoding.DecodeString(key.DER)
+		if err != nil {
+			return nil, err
+		}
+		pk, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return nil, err
+		}
+		sshKey, err := ssh.NewPublicKey(pk)
+		if err != nil {
+			return nil, err
+		}
+		line := bytes.TrimSuffix(ssh.MarshalAuthorizedKey(sshKey), []byte("\n"))
+		fmt.Fprintf(&buf, "%s %s\n", line, key.JWK.Kid)
+	}
+	return buf.Bytes(), nil
+}
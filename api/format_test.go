@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ssh"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// testKeys returns the keys converted from testdata/jwks.json, an RSA, an EC and an Ed25519 key
+func testKeys(t *testing.T) map[string]PublicKey {
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "jwks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jwks JWKS
+	if err := json.Unmarshal(body, &jwks); err != nil {
+		t.Fatal(err)
+	}
+	keySet, err := convertJwks(jwks, ConversionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keySet.Keys
+}
+
+// golden returns the contents of the golden file, first rewriting it with actual if the -update flag is set
+func golden(t *testing.T, name string, actual []byte) []byte {
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := ioutil.WriteFile(path, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return expected
+}
+
+func TestFormats(t *testing.T) {
+	keys := testKeys(t)
+
+	for _, f := range formats {
+		f := f
+		Convey("Given keys of every supported type, check they are rendered as "+f.Name+" as in the golden file", t, func() {
+			body, err := f.render(sortedKeys(keys))
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, string(golden(t, "keys."+f.Name, body)))
+		})
+	}
+
+	Convey("Given keys rendered as PEM, check each block parses back to the key", t, func() {
+		body, _ := renderPEM(sortedKeys(keys))
+		var blocks int
+		for block, rest := pem.Decode(body); block != nil; block, rest = pem.Decode(rest) {
+			So(block.Type, ShouldEqual, "PUBLIC KEY")
+			So(block.Bytes, ShouldNotBeEmpty)
+			blocks++
+		}
+		So(blocks, ShouldEqual, 3)
+	})
+
+	Convey("Given keys rendered as OpenSSH authorized_keys lines, check each line parses with its kid as the comment", t, func() {
+		body, _ := renderSSH(sortedKeys(keys))
+		var comments []string
+		for rest := body; len(rest) > 0; {
+			_, comment, _, next, err := ssh.ParseAuthorizedKey(rest)
+			So(err, ShouldBeNil)
+			comments = append(comments, comment)
+			rest = next
+		}
+		So(comments, ShouldResemble, []string{"ec-key", "j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8=", "okp-key"})
+	})
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	Convey("Given requests for keys, check the format is selected by query parameter, then Accept header", t, func() {
+		tests := []struct {
+			query  string
+			accept string
+			format Format
+			err    error
+		}{
+			{"", "", FormatDER, nil},
+			{"?format=der", "", FormatDER, nil},
+			{"?format=pem", "", FormatPEM, nil},
+			{"?format=jwk", "", FormatJWK, nil},
+			{"?format=ssh", "", FormatSSH, nil},
+			{"?format=pem", "application/jwk-set+json", FormatPEM, nil},
+			{"?format=xml", "", Format{}, ErrUnknownFormat},
+			{"", "application/json", FormatDER, nil},
+			{"", "application/x-pem-file", FormatPEM, nil},
+			{"", "application/jwk-set+json", FormatJWK, nil},
+			{"", "text/plain", FormatSSH, nil},
+			{"", "text/plain; charset=utf-8", FormatSSH, nil},
+			{"", "*/*", FormatDER, nil},
+			{"", "text/*", FormatSSH, nil},
+			{"", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", FormatDER, nil},
+			{"", "application/json;q=0.5, application/x-pem-file", FormatPEM, nil},
+			{"", "application/x-pem-file;q=0, application/jwk-set+json;q=0.1", FormatJWK, nil},
+			{"", "application/xml", Format{}, ErrNotAcceptable},
+			{"", "application/x-pem-file;q=0", Format{}, ErrNotAcceptable},
+		}
+		for _, test := range tests {
+			req := httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId"+test.query, nil)
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+
+			format, err := negotiateFormat(req)
+
+			So(err, ShouldEqual, test.err)
+			So(format.Name, ShouldEqual, test.format.Name)
+		}
+	})
+}
+
+func TestUserPoolIdHandlerFormats(t *testing.T) {
+	Convey("Given a user pool id handler", t, func() {
+		userPoolIdHandler := UserPoolIdHandler(ctx, new(MockJWKSRetriever))
+
+		Convey("When keys are requested as PEM, check PEM is returned with its content type", func() {
+			resp := httptest.NewRecorder()
+			userPoolIdHandler.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId?format=pem", nil))
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get("Content-Type"), ShouldEqual, "application/x-pem-file; charset=utf-8")
+			So(resp.Header().Get("Vary"), ShouldEqual, "Accept")
+			So(resp.Body.String(), ShouldStartWith, "kid: j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8=\n-----BEGIN PUBLIC KEY-----\n")
+		})
+
+		Convey("When keys are requested as the JWK set, check the JWKS retrieved is returned", func() {
+			req := httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil)
+			req.Header.Set("Accept", "application/jwk-set+json")
+			resp := httptest.NewRecorder()
+			userPoolIdHandler.ServeHTTP(resp, req)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get("Content-Type"), ShouldEqual, "application/jwk-set+json; charset=utf-8")
+			var jwks JWKS
+			So(json.Unmarshal(resp.Body.Bytes(), &jwks), ShouldBeNil)
+			So(jwks.Keys, ShouldHaveLength, 1)
+			So(jwks.Keys[0].N, ShouldEqual, validJWKS.Keys[0].N)
+		})
+
+		Convey("When keys are requested in an unknown format, check a 400 is returned", func() {
+			resp := httptest.NewRecorder()
+			userPoolIdHandler.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId?format=xml", nil))
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"InvalidFormat","description":"Unknown format. Must be one of: der, pem, jwk, ssh"}]}`)
+		})
+
+		Convey("When keys are requested with an Accept header allowing no format, check a 406 is returned", func() {
+			req := httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil)
+			req.Header.Set("Accept", "application/xml")
+			resp := httptest.NewRecorder()
+			userPoolIdHandler.ServeHTTP(resp, req)
+			So(resp.Code, ShouldEqual, http.StatusNotAcceptable)
+			So(resp.Header().Get("Content-Type"), ShouldEqual, "application/json; charset=utf-8")
+			So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"NotAcceptable","description":"None of the accepted media types are served. Must be one of: application/json, application/x-pem-file, application/jwk-set+json, text/plain"}]}`)
+		})
+	})
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// KeyRefresher is implemented by KeysRetrievers that can refresh a user pool's keys before they expire, such as
+// CachingJWKSRetriever, letting KidHandler pick up a key that Cognito has just rotated in
+type KeyRefresher interface {
+	RefreshKeys(ctx context.Context, region, userPoolId string) (KeySet, error)
+}
+
+// KidRefresher is implemented by KeysRetrievers that refresh a user pool's keys by looking for the kid they do not
+// have, such as those of consumers whose keys come from this service, letting retrieveKey pick up a key that Cognito
+// has just rotated in without waiting for their source to refresh. It is used in preference to KeyRefresher.
+type KidRefresher interface {
+	RefreshKid(ctx context.Context, region, userPoolId, kid string) (KeySet, error)
+}
+
+// KidHandler serves the key of a user pool with the kid given in the path, in the negotiated format
+func KidHandler(ctx context.Context, jr JWKSRetriever) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars, ok := pathVars(w, req, "region", "userPoolId", "kid")
+		if !ok {
+			return
+		}
+		region, userPoolId, kid := vars[0], vars[1], vars[2]
+		w.Header().Set("Vary", "Accept")
+		format, err := negotiateFormat(req)
+		if err != nil {
+			writeFormatError(w, err)
+			return
+		}
+		keySet, err := retrieveKey(req.Context(), jr, region, userPoolId, kid)
+		if err != nil {
+			writeRetrievalError(w, err, region, userPoolId)
+			return
+		}
+		key, ok := keySet.Keys[kid]
+		if !ok {
+			writeKidNotFound(w, keySet, kid, region, userPoolId)
+			return
+		}
+		setFreshnessHeaders(w.Header(), KeySet{Age: keySet.Age, Stale: keySet.Stale})
+		keys := map[string]PublicKey{kid: key}
+		setKeyInfoHeaders(w.Header(), keys)
+		writeKeys(w, format, keys)
+	}
+}
+
+// retrieveKey returns the public keys for a user pool, refreshing them once if they do not have the kid
+func retrieveKey(ctx context.Context, jr JWKSRetriever, region, userPoolId, kid string) (KeySet, error) {
+	keySet, err := retrieveKeys(ctx, jr, region, userPoolId)
+	if err != nil {
+		return KeySet{}, err
+	}
+	if _, ok := keySet.Keys[kid]; ok {
+		return keySet, nil
+	}
+	var refreshed KeySet
+	switch kr := jr.(type) {
+	case KidRefresher:
+		refreshed, err = kr.RefreshKid(ctx, region, userPoolId, kid)
+	case KeyRefresher:
+		refreshed, err = kr.RefreshKeys(ctx, region, userPoolId)
+	default:
+		return keySet, nil
+	}
+	if err == ErrRefreshSkipped {
+		return keySet, nil
+	}
+	if err != nil {
+		log.Printf("Failed to refresh keys for user pool %s in region %s for unknown kid %s.\nError:%s\n", userPoolId, region, kid, err.Error())
+		return keySet, nil
+	}
+	return refreshed, nil
+}
+
+// writeKidNotFound writes the error response for a kid that is not among a user pool's keys, saying why if the key
+// was left out of them
+func writeKidNotFound(w http.ResponseWriter, keySet KeySet, kid, region, userPoolId string) {
+	for _, keyErr := range keySet.KeyErrors {
+		if keyErr.Kid == kid {
+			writeErrorResponse(w, http.StatusNotFound, CodeKeyNotFound, fmt.Sprintf("Key %s of user pool %s in region %s was left out: %s", kid, userPoolId, region, keyErr.Reason))
+			return
+		}
+	}
+	writeErrorResponse(w, http.StatusNotFound, CodeKeyNotFound, fmt.Sprintf("Key %s not found in user pool %s in region %s. Try changing the kid.", kid, userPoolId, region))
+}
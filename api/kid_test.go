@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKidHandler(t *testing.T) {
+	kid := validJWKS.Keys[0].Kid
+
+	Convey("Given the API in front of a valid JWKS", t, func() {
+		r := mux.NewRouter()
+		Setup(ctx, r, MockJWKSRetriever{}, VerifyConfig{})
+
+		Convey("When a kid it has is requested, check only that key is returned", func() {
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/eu-west-2/pool/"+kid, nil))
+
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get("Content-Type"), ShouldEqual, "application/json; charset=utf-8")
+			So(resp.Body.String(), ShouldStartWith, `{"`+kid+`":"MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAvBvi`)
+			So(resp.Body.String(), ShouldNotContainSubstring, validJWKS.Keys[1].Kid)
+			So(resp.Header()["Key-Info"], ShouldHaveLength, 1)
+		})
+
+		Convey("When a kid it has is requested as PEM, check only that key is returned as PEM", func() {
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/eu-west-2/pool/"+kid+"?format=pem", nil))
+
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldStartWith, "kid: "+kid+"\n-----BEGIN PUBLIC KEY-----\n")
+			So(strings.Count(resp.Body.String(), "BEGIN PUBLIC KEY"), ShouldEqual, 1)
+		})
+
+		Convey("When a kid it does not have is requested, check a 404 saying so is returned", func() {
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/eu-west-2/pool/unknown", nil))
+
+			So(resp.Code, ShouldEqual, http.StatusNotFound)
+			So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"KeyNotFound","description":"Key unknown not found in user pool pool in region eu-west-2. Try changing the kid."}]}`)
+		})
+	})
+
+	Convey("Given the API in front of a JWKS whose kids hold slashes", t, func() {
+		kids := []string{"ab/cd+=", "ab//cd+=", "/ab+="}
+		keys := make([]string, len(kids))
+		for i, kid := range kids {
+			keys[i] = jsonKey(t, rsaJWKWithBits(kid, 2048))
+		}
+		jwks := `{"keys":[` + strings.Join(keys, ",") + `]}`
+		r := mux.NewRouter()
+		Setup(ctx, r, FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+			return &JWKSResponse{Body: io.NopCloser(strings.NewReader(jwks)), StatusCode: http.StatusOK}, nil
+		}), VerifyConfig{})
+
+		for _, kid := range kids {
+			for _, path := range []string{kid, url.PathEscape(kid)} {
+				Convey("When kid "+kid+" is requested as "+path+", check only that key is returned", func() {
+					resp := httptest.NewRecorder()
+					r.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/eu-west-2/pool/"+path, nil))
+
+					So(resp.Code, ShouldEqual, http.StatusOK)
+					So(resp.Header()["Key-Info"], ShouldHaveLength, 1)
+					So(resp.Header().Get("Key-Info"), ShouldStartWith, "kid="+strconv.Quote(kid)+";")
+				})
+			}
+		}
+	})
+
+	Convey("Given a JWKS with a key that fails the key policy, check a request for its kid says why it was left out", t, func() {
+		jwks := `{"keys":[` + jsonKey(t, rsaJWKWithBits("strong", 2048)) + "," + jsonKey(t, rsaJWKWithBits("weak", 512)) + `]}`
+		cache := NewCachingJWKSRetriever(FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+			return &JWKSResponse{Body: io.NopCloser(strings.NewReader(jwks)), StatusCode: http.StatusOK}, nil
+		}), CacheConfig{TTL: time.Minute, Conversion: ConversionConfig{Policy: KeyPolicy{MinRSABits: 2048}}})
+		resp := httptest.NewRecorder()
+
+		KidHandler(ctx, cache).ServeHTTP(resp, mux.SetURLVars(httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId/weak", nil), map[string]string{"region": "region", "userPoolId": "userPoolId", "kid": "weak"}))
+
+		So(resp.Code, ShouldEqual, http.StatusNotFound)
+		So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"KeyNotFound","description":"Key weak of user pool userPoolId in region region was left out: RSA modulus of 512 bits is shorter than the minimum of 2048"}]}`)
+	})
+
+	Convey("Given a user pool whose keys are cached", t, func() {
+		now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+		rotated := `{"keys":[` + jsonKey(t, validJWKS.Keys[0]) + "," + jsonKey(t, rsaJWKWithBits("rotated", 2048)) + `]}`
+		sjr := &SwitchableJWKSRetriever{current: MockJWKSRetriever{}}
+		cjr := &CountingJWKSRetriever{JWKSRetriever: sjr}
+		cache := NewCachingJWKSRetriever(cjr, CacheConfig{TTL: time.Hour, KidRefreshInterval: 30 * time.Second})
+		cache.now = func() time.Time { return now }
+		r := mux.NewRouter()
+		Setup(ctx, r, cache, VerifyConfig{})
+		get := func(kid string) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/eu-west-2/pool/"+kid, nil))
+			return resp
+		}
+
+		Convey("When a kid they do not have is requested as they are fetched, check they are not fetched again", func() {
+			So(get("unknown").Code, ShouldEqual, http.StatusNotFound)
+			So(cjr.Calls(), ShouldEqual, 1)
+		})
+
+		Convey("When Cognito rotates in a key and its kid is requested", func() {
+			So(get(kid).Code, ShouldEqual, http.StatusOK)
+			sjr.SwitchTo(FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+				return &JWKSResponse{Body: io.NopCloser(strings.NewReader(rotated)), StatusCode: http.StatusOK}, nil
+			}))
+			now = now.Add(time.Minute)
+			resp := get("rotated")
+
+			Convey("Then the keys are refreshed and the new key is returned", func() {
+				So(resp.Code, ShouldEqual, http.StatusOK)
+				So(resp.Body.String(), ShouldStartWith, `{"rotated":`)
+				So(cjr.Calls(), ShouldEqual, 2)
+			})
+
+			Convey("Then a request for another unknown kid within the refresh interval does not refresh them again", func() {
+				now = now.Add(10 * time.Second)
+				So(get("unknown").Code, ShouldEqual, http.StatusNotFound)
+				So(cjr.Calls(), ShouldEqual, 2)
+			})
+
+			Convey("Then a request for another unknown kid after the refresh interval refreshes them again", func() {
+				now = now.Add(time.Minute)
+				So(get("unknown").Code, ShouldEqual, http.StatusNotFound)
+				So(cjr.Calls(), ShouldEqual, 3)
+			})
+		})
+	})
+}
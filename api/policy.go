@@ -0,0 +1,113 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// PolicyAction is what is done with a JWKS holding keys that fail the key policy
+type PolicyAction string
+
+const (
+	// PolicyDrop leaves the failing keys out, serving the rest and reporting why each was left out
+	PolicyDrop PolicyAction = "drop"
+	// PolicyReject rejects the whole JWKS
+	PolicyReject PolicyAction = "reject"
+)
+
+// KeyPolicy is what a converted key must meet to be served as a signing key. The zero value allows every key.
+type KeyPolicy struct {
+	// MinRSABits, when greater than zero, is the shortest RSA modulus allowed
+	MinRSABits int
+	// AllowedAlgs, when not empty, are the only values of alg allowed
+	AllowedAlgs []string
+	// RequireSigUse only allows keys whose use is sig
+	RequireSigUse bool
+	// WeakExponents are RSA public exponents that are not allowed
+	WeakExponents []int
+	// Action is what is done with a JWKS holding keys that fail the policy, PolicyDrop unless it is PolicyReject
+	Action PolicyAction
+}
+
+// KeyError says why a key was left out of a KeySet
+type KeyError struct {
+	Kid    string `json:"kid"`
+	Reason string `json:"reason"`
+}
+
+// PolicyViolationError is returned when keys in a JWKS fail the key policy and it is rejected, or no keys are left
+type PolicyViolationError struct {
+	KeyErrors []KeyError
+}
+
+func (e *PolicyViolationError) Error() string {
+	return "keys fail the key policy: " + e.reasons()
+}
+
+func (e *PolicyViolationError) reasons() string {
+	return joinKeyErrors(e.KeyErrors)
+}
+
+// joinKeyErrors lists why each key was left out
+func joinKeyErrors(keyErrors []KeyError) string {
+	reasons := make([]string, len(keyErrors))
+	for i, keyErr := range keyErrors {
+		reasons[i] = keyErr.Kid + ": " + keyErr.Reason
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// apply checks every key of a KeySet against the policy, returning a KeySet of those that pass and why the others,
+// including any already left out, did not. A PolicyViolationError is returned if any fail under PolicyReject, or
+// none are left under PolicyDrop.
+func (p KeyPolicy) apply(converted KeySet) (KeySet, error) {
+	keySet := KeySet{Keys: make(map[string]PublicKey, len(converted.Keys))}
+	var violations []KeyError
+	for kid, key := range converted.Keys {
+		if reason := p.check(key); reason != "" {
+			log.Printf("Key %s fails the key policy: %s\n", kid, reason)
+			violations = append(violations, KeyError{Kid: kid, Reason: reason})
+			continue
+		}
+		keySet.Keys[kid] = key
+	}
+	keySet.KeyErrors = append(append([]KeyError(nil), converted.KeyErrors...), violations...)
+	sort.SliceStable(keySet.KeyErrors, func(i, j int) bool { return keySet.KeyErrors[i].Kid < keySet.KeyErrors[j].Kid })
+	if len(violations) > 0 && (p.Action == PolicyReject || len(keySet.Keys) == 0) {
+		return KeySet{}, &PolicyViolationError{KeyErrors: keySet.KeyErrors}
+	}
+	return keySet, nil
+}
+
+// check returns why a key fails the policy, or an empty string if it passes
+func (p KeyPolicy) check(key PublicKey) string {
+	if key.Kty == "RSA" && p.MinRSABits > 0 && key.Bits < p.MinRSABits {
+		return fmt.Sprintf("RSA modulus of %d bits is shorter than the minimum of %d", key.Bits, p.MinRSABits)
+	}
+	if len(p.AllowedAlgs) > 0 && !contains(p.AllowedAlgs, key.JWK.Alg) {
+		return fmt.Sprintf("alg %q is not one of the allowed algorithms %s", key.JWK.Alg, strings.Join(p.AllowedAlgs, ", "))
+	}
+	if p.RequireSigUse && key.JWK.Use != "sig" {
+		return fmt.Sprintf("use %q is not sig", key.JWK.Use)
+	}
+	if key.Kty == "RSA" && len(p.WeakExponents) > 0 {
+		e, _ := decodeExponent(key.JWK.E)
+		for _, weak := range p.WeakExponents {
+			if e == weak {
+				return fmt.Sprintf("RSA exponent %d is weak", e)
+			}
+		}
+	}
+	return ""
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// rsaJWKWithBits returns an RSA JWK whose modulus is the given number of bits long
+func rsaJWKWithBits(kid string, bits int) JsonKey {
+	n := make([]byte, bits/8)
+	for i := range n {
+		n[i] = 0xff
+	}
+	return JsonKey{Kty: "RSA", Kid: kid, Alg: "RS256", Use: "sig", E: "AQAB", N: b64.RawURLEncoding.EncodeToString(n)}
+}
+
+// policyTestKey converts a JWK, failing the test if it cannot be
+func policyTestKey(t *testing.T, jwk JsonKey) PublicKey {
+	key, err := convertJwk(jwk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKeyPolicyCheck(t *testing.T) {
+	Convey("Given keys checked against a key policy, check those that fail are given the reason", t, func() {
+		policy := KeyPolicy{
+			MinRSABits:    2048,
+			AllowedAlgs:   []string{"RS256", "ES256", "EdDSA"},
+			RequireSigUse: true,
+			WeakExponents: []int{3},
+		}
+		okpKey := rfc8037Key
+		okpKey.Alg, okpKey.Use = "EdDSA", "sig"
+		tests := []struct {
+			modify func(jwk *JsonKey)
+			jwk    JsonKey
+			reason string
+		}{
+			{jwk: validJWKS.Keys[0], reason: ""},
+			{jwk: rsaJWKWithBits("rsa", 4096), reason: ""},
+			{jwk: okpKey, reason: ""},
+			{jwk: rsaJWKWithBits("rsa", 512), reason: "RSA modulus of 512 bits is shorter than the minimum of 2048"},
+			{jwk: rsaJWKWithBits("rsa", 1024), reason: "RSA modulus of 1024 bits is shorter than the minimum of 2048"},
+			{jwk: validJWKS.Keys[0], modify: func(jwk *JsonKey) { jwk.Alg = "RS384" }, reason: `alg "RS384" is not one of the allowed algorithms RS256, ES256, EdDSA`},
+			{jwk: validJWKS.Keys[0], modify: func(jwk *JsonKey) { jwk.Alg = "" }, reason: `alg "" is not one of the allowed algorithms RS256, ES256, EdDSA`},
+			{jwk: validJWKS.Keys[0], modify: func(jwk *JsonKey) { jwk.Use = "enc" }, reason: `use "enc" is not sig`},
+			{jwk: okpKey, modify: func(jwk *JsonKey) { jwk.Use = "" }, reason: `use "" is not sig`},
+			{jwk: validJWKS.Keys[0], modify: func(jwk *JsonKey) { jwk.E = "Aw" }, reason: "RSA exponent 3 is weak"},
+			{jwk: validJWKS.Keys[0], modify: func(jwk *JsonKey) { jwk.E = "AAAD" }, reason: "RSA exponent 3 is weak"},
+		}
+		for _, test := range tests {
+			jwk := test.jwk
+			if test.modify != nil {
+				test.modify(&jwk)
+			}
+			So(policy.check(policyTestKey(t, jwk)), ShouldEqual, test.reason)
+		}
+	})
+
+	Convey("Given the zero key policy, check every key passes", t, func() {
+		jwk := rsaJWKWithBits("rsa", 512)
+		jwk.Alg, jwk.Use, jwk.E = "", "enc", "Aw"
+		So(KeyPolicy{}.check(policyTestKey(t, jwk)), ShouldEqual, "")
+	})
+}
+
+func TestKeyPolicyApply(t *testing.T) {
+	Convey("Given a JWKS with a key that fails the key policy", t, func() {
+		keys := map[string]PublicKey{
+			"strong": policyTestKey(t, rsaJWKWithBits("strong", 2048)),
+			"weak":   policyTestKey(t, rsaJWKWithBits("weak", 512)),
+		}
+
+		Convey("When the policy drops failing keys, check the others are kept and the reason reported", func() {
+			keySet, err := KeyPolicy{MinRSABits: 2048, Action: PolicyDrop}.apply(KeySet{Keys: keys})
+			So(err, ShouldBeNil)
+			So(keySet.Keys, ShouldHaveLength, 1)
+			So(keySet.Keys, ShouldContainKey, "strong")
+			So(keySet.KeyErrors, ShouldResemble, []KeyError{{Kid: "weak", Reason: "RSA modulus of 512 bits is shorter than the minimum of 2048"}})
+		})
+
+		Convey("When the policy rejects a JWKS with failing keys, check it is rejected with the reason", func() {
+			keySet, err := KeyPolicy{MinRSABits: 2048, Action: PolicyReject}.apply(KeySet{Keys: keys})
+			So(keySet.Keys, ShouldBeNil)
+			So(err, ShouldResemble, &PolicyViolationError{KeyErrors: []KeyError{{Kid: "weak", Reason: "RSA modulus of 512 bits is shorter than the minimum of 2048"}}})
+			So(err.Error(), ShouldEqual, "keys fail the key policy: weak: RSA modulus of 512 bits is shorter than the minimum of 2048")
+		})
+
+		Convey("When the policy drops every key, check the JWKS is rejected", func() {
+			_, err := KeyPolicy{MinRSABits: 4096, Action: PolicyDrop}.apply(KeySet{Keys: keys})
+			So(err, ShouldHaveSameTypeAs, &PolicyViolationError{})
+			So(err.(*PolicyViolationError).KeyErrors, ShouldHaveLength, 2)
+		})
+	})
+}
+
+func TestKeyPolicyReported(t *testing.T) {
+	Convey("Given a user pool with a key that fails the key policy served through a cache", t, func() {
+		jwks := `{"keys":[` + strings.Join([]string{jsonKey(t, rsaJWKWithBits("strong", 2048)), jsonKey(t, rsaJWKWithBits("weak", 512))}, ",") + `]}`
+		retriever := FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+			return &JWKSResponse{Body: io.NopCloser(strings.NewReader(jwks)), StatusCode: http.StatusOK}, nil
+		})
+		conversion := ConversionConfig{Policy: KeyPolicy{MinRSABits: 2048}}
+
+		Convey("When its keys are requested, check a warning says why the key was left out", func() {
+			cache := NewCachingJWKSRetriever(retriever, CacheConfig{TTL: time.Minute, Conversion: conversion})
+			resp := httptest.NewRecorder()
+			UserPoolIdHandler(ctx, cache).ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil))
+
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get("Warning"), ShouldEqual, `199 - "key weak left out: RSA modulus of 512 bits is shorter than the minimum of 2048"`)
+			So(resp.Body.String(), ShouldContainSubstring, `"strong"`)
+			So(resp.Body.String(), ShouldNotContainSubstring, `"weak"`)
+		})
+
+		Convey("When its keys are requested from v2, check the response lists why the key was left out", func() {
+			cache := NewCachingJWKSRetriever(retriever, CacheConfig{TTL: time.Minute, Conversion: conversion})
+			resp := httptest.NewRecorder()
+			UserPoolIdV2Handler(ctx, cache).ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/v2/region/userPoolId", nil))
+
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldEndWith, `"key_errors":[{"kid":"weak","reason":"RSA modulus of 512 bits is shorter than the minimum of 2048"}]}`)
+		})
+
+		Convey("When the policy rejects it and its keys are requested, check a 502 gives the reason", func() {
+			conversion.Policy.Action = PolicyReject
+			cache := NewCachingJWKSRetriever(retriever, CacheConfig{TTL: time.Minute, Conversion: conversion})
+			resp := httptest.NewRecorder()
+			UserPoolIdHandler(ctx, cache).ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil))
+
+			So(resp.Code, ShouldEqual, http.StatusBadGateway)
+			So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"KeyPolicyViolation","description":"The JWKS from AWS Cognito has keys that fail the key policy: weak: RSA modulus of 512 bits is shorter than the minimum of 2048"}]}`)
+		})
+	})
+}
+
+func jsonKey(t *testing.T, jwk JsonKey) string {
+	b, err := json.Marshal(jwk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
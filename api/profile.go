@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Cognito token validation failure codes, returned in a TokenError
+const (
+	CodeInvalidTokenUse = "InvalidTokenUse"
+	CodeInvalidAudience = "InvalidAudience"
+	CodeInvalidClientId = "InvalidClientId"
+	CodeMissingScope    = "MissingScope"
+	CodeNotInGroup      = "NotInGroup"
+)
+
+// Token uses of Cognito tokens, given by their token_use claim
+const (
+	TokenUseID     = "id"
+	TokenUseAccess = "access"
+)
+
+// CognitoProfile is the Cognito specific rules the tokens of a user pool must meet. A token use without rules is not
+// accepted.
+type CognitoProfile struct {
+	Region      string            `json:"region"`
+	UserPoolId  string            `json:"user_pool_id"`
+	IDToken     *IDTokenRules     `json:"id_token,omitempty"`
+	AccessToken *AccessTokenRules `json:"access_token,omitempty"`
+}
+
+// IDTokenRules are the rules an ID token must meet
+type IDTokenRules struct {
+	// ClientIds are the app clients the aud of a token must be one of
+	ClientIds []string `json:"client_ids"`
+}
+
+// AccessTokenRules are the rules an access token must meet
+type AccessTokenRules struct {
+	// ClientIds are the app clients the client_id of a token must be one of
+	ClientIds []string `json:"client_ids"`
+	// RequiredScopes, when not empty, must all be in the scope of a token
+	RequiredScopes []string `json:"required_scopes,omitempty"`
+	// AllowedGroups, when not empty, are the groups the cognito:groups of a token must include at least one of
+	AllowedGroups []string `json:"allowed_groups,omitempty"`
+}
+
+// Validate checks that a token meets the rules for its token_use, and that it is of the given token use if one is
+// given
+func (p CognitoProfile) Validate(t *Token, tokenUse string) error {
+	use, _ := t.Claims["token_use"].(string)
+	if tokenUse != "" && use != tokenUse {
+		return tokenError(CodeInvalidTokenUse, "token_use %q is not %q", use, tokenUse)
+	}
+	switch {
+	case use == TokenUseID && p.IDToken != nil:
+		aud, _ := t.Claims["aud"].(string)
+		if !contains(p.IDToken.ClientIds, aud) {
+			return tokenError(CodeInvalidAudience, "aud %q is not an allowed app client", aud)
+		}
+	case use == TokenUseAccess && p.AccessToken != nil:
+		clientId, _ := t.Claims["client_id"].(string)
+		if !contains(p.AccessToken.ClientIds, clientId) {
+			return tokenError(CodeInvalidClientId, "client_id %q is not an allowed app client", clientId)
+		}
+		scope, _ := t.Claims["scope"].(string)
+		scopes := strings.Fields(scope)
+		for _, required := range p.AccessToken.RequiredScopes {
+			if !contains(scopes, required) {
+				return tokenError(CodeMissingScope, "scope does not include %s", required)
+			}
+		}
+		if len(p.AccessToken.AllowedGroups) > 0 && !inAnyGroup(t.Claims["cognito:groups"], p.AccessToken.AllowedGroups) {
+			return tokenError(CodeNotInGroup, "cognito:groups does not include any of %s", strings.Join(p.AccessToken.AllowedGroups, ", "))
+		}
+	default:
+		return tokenError(CodeInvalidTokenUse, "token_use %q is not accepted by user pool %s", use, p.UserPoolId)
+	}
+	return nil
+}
+
+func inAnyGroup(claim interface{}, groups []string) bool {
+	memberOf, _ := claim.([]interface{})
+	for _, group := range memberOf {
+		if name, ok := group.(string); ok && contains(groups, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// CognitoProfiles are the CognitoProfiles of user pools, keyed on region and user pool ID
+type CognitoProfiles map[string]CognitoProfile
+
+// Get returns the profile of a user pool, if it has one
+func (ps CognitoProfiles) Get(region, userPoolId string) (CognitoProfile, bool) {
+	p, ok := ps[cacheKey{region: region, userPoolId: userPoolId}.String()]
+	return p, ok
+}
+
+// Validate checks that a token meets the profile of its user pool, if it has one, and that it is of the given token
+// use if one is given
+func (ps CognitoProfiles) Validate(region, userPoolId string, t *Token, tokenUse string) error {
+	if p, ok := ps.Get(region, userPoolId); ok {
+		return p.Validate(t, tokenUse)
+	}
+	if use, _ := t.Claims["token_use"].(string); tokenUse != "" && use != tokenUse {
+		return tokenError(CodeInvalidTokenUse, "token_use %q is not %q", use, tokenUse)
+	}
+	return nil
+}
+
+// NewCognitoProfiles checks that every profile is for a different user pool and accepts some app client
+func NewCognitoProfiles(profiles []CognitoProfile) (CognitoProfiles, error) {
+	ps := make(CognitoProfiles, len(profiles))
+	for i, p := range profiles {
+		key := cacheKey{region: p.Region, userPoolId: p.UserPoolId}.String()
+		switch {
+		case p.Region == "" || p.UserPoolId == "":
+			return nil, fmt.Errorf("profile %d has no region or user_pool_id", i)
+		case p.IDToken == nil && p.AccessToken == nil:
+			return nil, fmt.Errorf("profile for %s accepts neither id_token nor access_token", key)
+		case p.IDToken != nil && len(p.IDToken.ClientIds) == 0, p.AccessToken != nil && len(p.AccessToken.ClientIds) == 0:
+			return nil, fmt.Errorf("profile for %s has no client_ids", key)
+		}
+		if _, ok := ps[key]; ok {
+			return nil, fmt.Errorf("more than one profile for %s", key)
+		}
+		ps[key] = p
+	}
+	return ps, nil
+}
+
+// LoadCognitoProfiles reads a JSON array of CognitoProfiles from a file
+func LoadCognitoProfiles(path string) (CognitoProfiles, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var profiles []CognitoProfile
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&profiles); err != nil {
+		return nil, fmt.Errorf("error decoding Cognito profiles %s: %s", path, err.Error())
+	}
+	return NewCognitoProfiles(profiles)
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCognitoProfileValidate(t *testing.T) {
+	profile := CognitoProfile{
+		Region:      "eu-west-2",
+		UserPoolId:  "eu-west-2_abc",
+		IDToken:     &IDTokenRules{ClientIds: []string{"florence"}},
+		AccessToken: &AccessTokenRules{ClientIds: []string{"florence", "zebedee"}, RequiredScopes: []string{"dp/read"}, AllowedGroups: []string{"publishers", "admins"}},
+	}
+	token := func(claims map[string]interface{}) *Token {
+		return &Token{Claims: claims}
+	}
+	accessToken := func(overrides map[string]interface{}) *Token {
+		claims := map[string]interface{}{
+			"token_use":      "access",
+			"client_id":      "zebedee",
+			"scope":          "openid dp/read",
+			"cognito:groups": []interface{}{"viewers", "publishers"},
+		}
+		for name, value := range overrides {
+			if value == nil {
+				delete(claims, name)
+				continue
+			}
+			claims[name] = value
+		}
+		return token(claims)
+	}
+
+	Convey("Given tokens that meet the profile, check they validate", t, func() {
+		So(profile.Validate(token(map[string]interface{}{"token_use": "id", "aud": "florence"}), ""), ShouldBeNil)
+		So(profile.Validate(token(map[string]interface{}{"token_use": "id", "aud": "florence"}), TokenUseID), ShouldBeNil)
+		So(profile.Validate(accessToken(nil), ""), ShouldBeNil)
+		So(profile.Validate(accessToken(nil), TokenUseAccess), ShouldBeNil)
+	})
+
+	Convey("Given tokens that do not meet the profile, check each fails with the reason", t, func() {
+		tests := []struct {
+			token    *Token
+			tokenUse string
+			err      *TokenError
+		}{
+			{token(map[string]interface{}{"token_use": "id", "aud": "zebedee"}), "", &TokenError{Code: CodeInvalidAudience, Reason: `aud "zebedee" is not an allowed app client`}},
+			{token(map[string]interface{}{"token_use": "id"}), "", &TokenError{Code: CodeInvalidAudience, Reason: `aud "" is not an allowed app client`}},
+			{token(map[string]interface{}{"token_use": "id", "aud": "florence"}), TokenUseAccess, &TokenError{Code: CodeInvalidTokenUse, Reason: `token_use "id" is not "access"`}},
+			{token(map[string]interface{}{"token_use": "refresh"}), "", &TokenError{Code: CodeInvalidTokenUse, Reason: `token_use "refresh" is not accepted by user pool eu-west-2_abc`}},
+			{token(map[string]interface{}{}), "", &TokenError{Code: CodeInvalidTokenUse, Reason: `token_use "" is not accepted by user pool eu-west-2_abc`}},
+			{accessToken(nil), TokenUseID, &TokenError{Code: CodeInvalidTokenUse, Reason: `token_use "access" is not "id"`}},
+			{accessToken(map[string]interface{}{"client_id": "babbage"}), "", &TokenError{Code: CodeInvalidClientId, Reason: `client_id "babbage" is not an allowed app client`}},
+			{accessToken(map[string]interface{}{"scope": "openid"}), "", &TokenError{Code: CodeMissingScope, Reason: "scope does not include dp/read"}},
+			{accessToken(map[string]interface{}{"scope": nil}), "", &TokenError{Code: CodeMissingScope, Reason: "scope does not include dp/read"}},
+			{accessToken(map[string]interface{}{"cognito:groups": []interface{}{"viewers"}}), "", &TokenError{Code: CodeNotInGroup, Reason: "cognito:groups does not include any of publishers, admins"}},
+			{accessToken(map[string]interface{}{"cognito:groups": nil}), "", &TokenError{Code: CodeNotInGroup, Reason: "cognito:groups does not include any of publishers, admins"}},
+		}
+		for _, test := range tests {
+			So(profile.Validate(test.token, test.tokenUse), ShouldResemble, test.err)
+		}
+	})
+
+	Convey("Given a profile that only accepts ID tokens, check an access token is not accepted", t, func() {
+		idOnly := CognitoProfile{Region: "eu-west-2", UserPoolId: "eu-west-2_abc", IDToken: profile.IDToken}
+		So(idOnly.Validate(accessToken(nil), ""), ShouldResemble, &TokenError{Code: CodeInvalidTokenUse, Reason: `token_use "access" is not accepted by user pool eu-west-2_abc`})
+	})
+
+	Convey("Given profiles", t, func() {
+		profiles, err := NewCognitoProfiles([]CognitoProfile{profile})
+		So(err, ShouldBeNil)
+
+		Convey("When a token of a user pool with a profile is validated, check the profile is applied", func() {
+			So(profiles.Validate("eu-west-2", "eu-west-2_abc", accessToken(map[string]interface{}{"client_id": "babbage"}), ""), ShouldHaveSameTypeAs, &TokenError{})
+		})
+
+		Convey("When a token of a user pool without a profile is validated, check only its token use is checked", func() {
+			So(profiles.Validate("eu-west-2", "eu-west-2_xyz", accessToken(map[string]interface{}{"client_id": "babbage"}), ""), ShouldBeNil)
+			So(profiles.Validate("eu-west-2", "eu-west-2_xyz", accessToken(nil), TokenUseID), ShouldResemble, &TokenError{Code: CodeInvalidTokenUse, Reason: `token_use "access" is not "id"`})
+		})
+	})
+}
+
+func TestNewCognitoProfiles(t *testing.T) {
+	Convey("Given invalid profiles, check each is rejected with the reason", t, func() {
+		rules := &IDTokenRules{ClientIds: []string{"florence"}}
+		tests := []struct {
+			profiles []CognitoProfile
+			err      string
+		}{
+			{[]CognitoProfile{{UserPoolId: "eu-west-2_abc", IDToken: rules}}, "profile 0 has no region or user_pool_id"},
+			{[]CognitoProfile{{Region: "eu-west-2", UserPoolId: "eu-west-2_abc"}}, "profile for eu-west-2/eu-west-2_abc accepts neither id_token nor access_token"},
+			{[]CognitoProfile{{Region: "eu-west-2", UserPoolId: "eu-west-2_abc", IDToken: &IDTokenRules{}}}, "profile for eu-west-2/eu-west-2_abc has no client_ids"},
+			{[]CognitoProfile{{Region: "eu-west-2", UserPoolId: "eu-west-2_abc", AccessToken: &AccessTokenRules{RequiredScopes: []string{"dp/read"}}}}, "profile for eu-west-2/eu-west-2_abc has no client_ids"},
+			{[]CognitoProfile{{Region: "eu-west-2", UserPoolId: "eu-west-2_abc", IDToken: rules}, {Region: "eu-west-2", UserPoolId: "eu-west-2_abc", IDToken: rules}}, "more than one profile for eu-west-2/eu-west-2_abc"},
+		}
+		for _, test := range tests {
+			_, err := NewCognitoProfiles(test.profiles)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, test.err)
+		}
+	})
+}
+
+func TestLoadCognitoProfiles(t *testing.T) {
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "profiles.json")
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	Convey("Given a file of profiles, check they are loaded by user pool", t, func() {
+		profiles, err := LoadCognitoProfiles(write(`[{"region":"eu-west-2","user_pool_id":"eu-west-2_abc","access_token":{"client_ids":["zebedee"],"required_scopes":["dp/read"]}}]`))
+		So(err, ShouldBeNil)
+		profile, ok := profiles.Get("eu-west-2", "eu-west-2_abc")
+		So(ok, ShouldBeTrue)
+		So(profile.AccessToken, ShouldResemble, &AccessTokenRules{ClientIds: []string{"zebedee"}, RequiredScopes: []string{"dp/read"}})
+		So(profile.IDToken, ShouldBeNil)
+	})
+
+	Convey("Given a file of profiles with an unknown member, check it is rejected", t, func() {
+		path := write(`[{"region":"eu-west-2","user_pool_id":"eu-west-2_abc","id_token":{"client_ids":["florence"],"audience":"x"}}]`)
+		_, err := LoadCognitoProfiles(path)
+		So(err.Error(), ShouldEqual, "error decoding Cognito profiles "+path+`: json: unknown field "audience"`)
+	})
+
+	Convey("Given a file that does not exist, check it is an error", t, func() {
+		_, err := LoadCognitoProfiles(filepath.Join(t.TempDir(), "missing.json"))
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errInvalidJWKSURL is returned when the JWKS URL filled in from its template cannot be requested
+var errInvalidJWKSURL = errors.New("invalid JWKS URL")
+
+// JWKSRetriever requests the JWKS of a user pool. The metadata of a previous response for the same user pool
+// may be given so that the request can be made conditional; the zero value makes an unconditional request.
+type JWKSRetriever interface {
+	RetrieveJWKS(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error)
+}
+
+// JWKSResponse is the response to a JWKS request. The caller is responsible for closing Body.
+type JWKSResponse struct {
+	Body       io.ReadCloser
+	StatusCode int
+	Metadata   ResponseMetadata
+}
+
+// ResponseMetadata holds the caching headers of a JWKS response
+type ResponseMetadata struct {
+	// MaxAge is how long the response may be cached for, only meaningful if HasMaxAge is set
+	MaxAge       time.Duration
+	HasMaxAge    bool
+	ETag         string
+	LastModified string
+}
+
+// DefaultJWKSURLTemplate is the location of a user pool's JWKS in AWS Cognito
+const DefaultJWKSURLTemplate = "https://cognito-idp.{region}.amazonaws.com/{userPoolId}/.well-known/jwks.json"
+
+// CognitoJWKSRetriever requests JWKS from AWS Cognito, or from a stand-in for it if URLTemplate is set
+type CognitoJWKSRetriever struct {
+	// Client makes the requests, http.DefaultClient if nil
+	Client *http.Client
+	// URLTemplate is the JWKS URL with {region} and {userPoolId} placeholders, DefaultJWKSURLTemplate if empty
+	URLTemplate string
+}
+
+// NewCognitoJWKSRetriever returns a CognitoJWKSRetriever that makes its requests with the given client
+func NewCognitoJWKSRetriever(client *http.Client, urlTemplate string) CognitoJWKSRetriever {
+	return CognitoJWKSRetriever{
+		Client:      client,
+		URLTemplate: urlTemplate,
+	}
+}
+
+// NewHTTPClient returns a client for requesting JWKS, which gives up on connecting after connectTimeout, on the
+// TLS handshake after tlsHandshakeTimeout and on the whole request, including reading the body, after timeout
+func NewHTTPClient(connectTimeout, tlsHandshakeTimeout, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         (&net.Dialer{Timeout: connectTimeout}).DialContext,
+			TLSHandshakeTimeout: tlsHandshakeTimeout,
+			MaxIdleConnsPerHost: 10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// RetrieveJWKS requests the JWKS of a user pool, abandoning the request if ctx is cancelled
+func (cjr CognitoJWKSRetriever) RetrieveJWKS(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cjr.jwksURL(region, userPoolId), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidJWKSURL, err.Error())
+	}
+	setConditionalHeaders(req, prev)
+	client := cjr.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, newUpstreamError(err)
+	}
+	return &JWKSResponse{
+		Body:       resp.Body,
+		StatusCode: resp.StatusCode,
+		Metadata:   parseResponseMetadata(resp.Header),
+	}, nil
+}
+
+// jwksURL fills in the URL template, escaping the values so that they cannot change which host is requested
+func (cjr CognitoJWKSRetriever) jwksURL(region, userPoolId string) string {
+	urlTemplate := cjr.URLTemplate
+	if urlTemplate == "" {
+		urlTemplate = DefaultJWKSURLTemplate
+	}
+	return strings.NewReplacer(
+		"{region}", url.PathEscape(region),
+		"{userPoolId}", url.PathEscape(userPoolId),
+	).Replace(urlTemplate)
+}
+
+// setConditionalHeaders makes the request conditional on the JWKS having changed since the previous response
+func setConditionalHeaders(req *http.Request, prev ResponseMetadata) {
+	if prev.ETag != "" {
+		req.Header.Set("If-None-Match", prev.ETag)
+	}
+	if prev.LastModified != "" {
+		req.Header.Set("If-Modified-Since", prev.LastModified)
+	}
+}
+
+// parseResponseMetadata reads the caching headers of a response. As a shared cache, s-maxage takes precedence
+// over max-age, and no-cache or no-store mean the response must be revalidated before it is used again.
+func parseResponseMetadata(h http.Header) ResponseMetadata {
+	metadata := ResponseMetadata{
+		ETag:         h.Get("ETag"),
+		LastModified: h.Get("Last-Modified"),
+	}
+	var maxAge, sMaxAge string
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		name, value := directive, ""
+		if i := strings.Index(directive, "="); i >= 0 {
+			name, value = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "max-age":
+			maxAge = value
+		case "s-maxage":
+			sMaxAge = value
+		case "no-cache", "no-store":
+			metadata.HasMaxAge = true
+			return metadata
+		}
+	}
+	if sMaxAge != "" {
+		maxAge = sMaxAge
+	}
+	if seconds, err := strconv.Atoi(maxAge); err == nil && seconds >= 0 {
+		metadata.MaxAge = time.Duration(seconds) * time.Second
+		metadata.HasMaxAge = true
+	}
+	return metadata
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseResponseMetadata(t *testing.T) {
+	Convey("Given the caching headers of a JWKS response, check the expected metadata is parsed", t, func() {
+		tests := []struct {
+			cacheControl string
+			maxAge       time.Duration
+			hasMaxAge    bool
+		}{
+			{"", 0, false},
+			{"public", 0, false},
+			{"max-age=3600", time.Hour, true},
+			{"public, max-age=600, must-revalidate", 10 * time.Minute, true},
+			{"max-age=3600, s-maxage=60", time.Minute, true},
+			{`max-age="120"`, 2 * time.Minute, true},
+			{"MAX-AGE=30", 30 * time.Second, true},
+			{"max-age=abc", 0, false},
+			{"max-age=-1", 0, false},
+			{"no-cache", 0, true},
+			{"max-age=3600, no-store", 0, true},
+		}
+		for _, test := range tests {
+			h := http.Header{}
+			h.Set("Cache-Control", test.cacheControl)
+			h.Set("ETag", `"abc123"`)
+			h.Set("Last-Modified", "Fri, 01 Oct 2021 12:00:00 GMT")
+
+			metadata := parseResponseMetadata(h)
+
+			So(metadata.MaxAge, ShouldEqual, test.maxAge)
+			So(metadata.HasMaxAge, ShouldEqual, test.hasMaxAge)
+			So(metadata.ETag, ShouldEqual, `"abc123"`)
+			So(metadata.LastModified, ShouldEqual, "Fri, 01 Oct 2021 12:00:00 GMT")
+		}
+	})
+}
+
+func TestSetConditionalHeaders(t *testing.T) {
+	Convey("Given a request for a JWKS", t, func() {
+		req := httptest.NewRequest("GET", "https://cognito-idp.eu-west-2.amazonaws.com/pool/.well-known/jwks.json", nil)
+
+		Convey("When there is no previous response, check the request is unconditional", func() {
+			setConditionalHeaders(req, ResponseMetadata{})
+			So(req.Header.Get("If-None-Match"), ShouldEqual, "")
+			So(req.Header.Get("If-Modified-Since"), ShouldEqual, "")
+		})
+
+		Convey("When the previous response had validators, check the request is conditional on them", func() {
+			setConditionalHeaders(req, ResponseMetadata{ETag: `"abc123"`, LastModified: "Fri, 01 Oct 2021 12:00:00 GMT"})
+			So(req.Header.Get("If-None-Match"), ShouldEqual, `"abc123"`)
+			So(req.Header.Get("If-Modified-Since"), ShouldEqual, "Fri, 01 Oct 2021 12:00:00 GMT")
+		})
+	})
+}
+
+func TestCognitoJWKSRetriever(t *testing.T) {
+	Convey("Given a stand-in for Cognito serving the JWKS of a user pool", t, func() {
+		var requests []*http.Request
+		stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			requests = append(requests, req)
+			if req.URL.Path != "/eu-west-2/eu-west-2_abc/.well-known/jwks.json" {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"message":"User pool eu-west-2_xyz does not exist."}`))
+				return
+			}
+			w.Header().Set("Cache-Control", "max-age=300")
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte(`{"keys":[]}`))
+		}))
+		defer stub.Close()
+		cjr := CognitoJWKSRetriever{URLTemplate: stub.URL + "/{region}/{userPoolId}/.well-known/jwks.json"}
+
+		Convey("When the JWKS of a known user pool is requested", func() {
+			resp, err := cjr.RetrieveJWKS(ctx, "eu-west-2", "eu-west-2_abc", ResponseMetadata{ETag: `"v0"`})
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			Convey("Then the request goes to the templated URL with the previous ETag", func() {
+				So(requests, ShouldHaveLength, 1)
+				So(requests[0].Header.Get("If-None-Match"), ShouldEqual, `"v0"`)
+			})
+
+			Convey("Then the body, status and caching metadata are returned", func() {
+				So(string(body), ShouldEqual, `{"keys":[]}`)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Metadata, ShouldResemble, ResponseMetadata{MaxAge: 5 * time.Minute, HasMaxAge: true, ETag: `"v1"`})
+			})
+		})
+
+		Convey("When the JWKS of an unknown user pool is requested, check the 404 is returned", func() {
+			resp, err := cjr.RetrieveJWKS(ctx, "eu-west-2", "eu-west-2_xyz", ResponseMetadata{})
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
+
+func TestJWKSURL(t *testing.T) {
+	Convey("Given a retriever without a URL template, check the AWS Cognito URL is used", t, func() {
+		cjr := CognitoJWKSRetriever{}
+		So(cjr.jwksURL("eu-west-2", "eu-west-2_abc"), ShouldEqual, "https://cognito-idp.eu-west-2.amazonaws.com/eu-west-2_abc/.well-known/jwks.json")
+	})
+
+	Convey("Given a retriever with a URL template, check the placeholders are filled in", t, func() {
+		cjr := CognitoJWKSRetriever{URLTemplate: "http://localhost:9229/{userPoolId}/.well-known/jwks.json?region={region}"}
+		So(cjr.jwksURL("eu-west-2", "eu-west-2_abc"), ShouldEqual, "http://localhost:9229/eu-west-2_abc/.well-known/jwks.json?region=eu-west-2")
+	})
+
+	Convey("Given values that would change the requested host, check they are escaped", t, func() {
+		cjr := CognitoJWKSRetriever{}
+		So(cjr.jwksURL("evil.example.com?", "eu-west-2_abc"), ShouldEqual, "https://cognito-idp.evil.example.com%3F.amazonaws.com/eu-west-2_abc/.well-known/jwks.json")
+		So(cjr.jwksURL("evil.example.com#", "a@b"), ShouldEqual, "https://cognito-idp.evil.example.com%23.amazonaws.com/a@b/.well-known/jwks.json")
+	})
+}
+
+func TestCognitoJWKSRetrieverTimeouts(t *testing.T) {
+	Convey("Given a stand-in for Cognito that hangs", t, func() {
+		hang := make(chan struct{})
+		stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			select {
+			case <-hang:
+			case <-req.Context().Done():
+			}
+		}))
+		defer stub.Close()
+		defer close(hang)
+		urlTemplate := stub.URL + "/{region}/{userPoolId}/.well-known/jwks.json"
+
+		Convey("When the request takes longer than the client's timeout, check it is abandoned", func() {
+			cjr := NewCognitoJWKSRetriever(NewHTTPClient(time.Second, time.Second, 50*time.Millisecond), urlTemplate)
+			start := time.Now()
+			resp, err := cjr.RetrieveJWKS(ctx, "eu-west-2", "eu-west-2_abc", ResponseMetadata{})
+			So(resp, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+		})
+
+		Convey("When the request's context is cancelled, check it is abandoned", func() {
+			cjr := NewCognitoJWKSRetriever(NewHTTPClient(time.Second, time.Second, time.Minute), urlTemplate)
+			cancelCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			resp, err := cjr.RetrieveJWKS(cancelCtx, "eu-west-2", "eu-west-2_abc", ResponseMetadata{})
+			So(resp, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+		})
+	})
+
+	Convey("Given a new HTTP client, check the timeouts are set", t, func() {
+		client := NewHTTPClient(time.Second, 2*time.Second, 3*time.Second)
+		So(client.Timeout, ShouldEqual, 3*time.Second)
+		So(client.Transport.(*http.Transport).TLSHandshakeTimeout, ShouldEqual, 2*time.Second)
+	})
+}
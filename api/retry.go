@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy controls how RetryingJWKSRetriever retries requests that fail transiently
+type RetryPolicy struct {
+	// MaxAttempts is the most requests made, including the first. One or less disables retries.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry, doubled for each retry after it up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter is the fraction, from 0 to 1, of each delay that is randomised so that callers do not retry in step
+	Jitter float64
+}
+
+// clock abstracts waiting so that backoff can be tested without sleeping
+type clock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// RetryingJWKSRetriever decorates a JWKSRetriever, retrying requests that fail with a network error or a status
+// that suggests Cognito may succeed if asked again, but not those that fail permanently, waiting with exponential backoff and jitter between attempts
+type RetryingJWKSRetriever struct {
+	retriever JWKSRetriever
+	policy    RetryPolicy
+	clock     clock
+	random    func() float64
+}
+
+// NewRetryingJWKSRetriever returns a RetryingJWKSRetriever in front of the given retriever
+func NewRetryingJWKSRetriever(jr JWKSRetriever, policy RetryPolicy) *RetryingJWKSRetriever {
+	return &RetryingJWKSRetriever{
+		retriever: jr,
+		policy:    policy,
+		clock:     realClock{},
+		random:    rand.Float64,
+	}
+}
+
+// RetrieveJWKS requests the JWKS, retrying as the policy allows. The result of the last attempt is returned.
+func (r *RetryingJWKSRetriever) RetrieveJWKS(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := r.retriever.RetrieveJWKS(ctx, region, userPoolId, prev)
+		if attempt >= r.policy.MaxAttempts || !isRetryable(ctx, resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		select {
+		case <-r.clock.After(r.delay(attempt)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// delay returns how long to wait after the given attempt
+func (r *RetryingJWKSRetriever) delay(attempt int) time.Duration {
+	return r.policy.Backoff(attempt, r.random())
+}
+
+// Backoff returns how long to wait after the given attempt, starting at 1, with random, from 0 to 1, choosing the
+// jitter
+func (p RetryPolicy) Backoff(attempt int, random float64) time.Duration {
+	backoff := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if p.MaxDelay > 0 && backoff > float64(p.MaxDelay) {
+		backoff = float64(p.MaxDelay)
+	}
+	jitter := math.Min(math.Max(p.Jitter, 0), 1)
+	return time.Duration(backoff * (1 - jitter*random))
+}
+
+// isRetryable reports whether a request might succeed if repeated: it failed with a network error that is not
+// permanent, was throttled or Cognito had a server error. Requests for unknown user pools, or whose caller has gone,
+// are never retried.
+func isRetryable(ctx context.Context, resp *JWKSResponse, err error) bool {
+	return ctx.Err() == nil && isUpstreamFailure(resp, err)
+}
+
+// isUpstreamFailure reports whether a request failed because of a problem reaching or with Cognito itself that may
+// clear if it is asked again
+func isUpstreamFailure(resp *JWKSResponse, err error) bool {
+	if err != nil {
+		return !isPermanentError(err)
+	}
+	return IsRetryableStatus(resp.StatusCode)
+}
+
+// isPermanentError reports whether a request failed in a way that asking again cannot fix: the JWKS URL is not valid,
+// its host does not exist or the TLS handshake was rejected, such as for an untrusted certificate. Timeouts, refused
+// or reset connections and other transport errors are not permanent.
+func isPermanentError(err error) bool {
+	if errors.Is(err, errInvalidJWKSURL) {
+		return true
+	}
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	switch upstreamErr.Kind {
+	case UpstreamTLSFailure:
+		return true
+	case UpstreamDNSFailure:
+		return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+	default:
+		return false
+	}
+}
+
+// IsRetryableStatus reports whether a request that got a response with the status code might succeed if repeated:
+// it was throttled or the server had an error
+func IsRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeClock fires immediately, recording how long each wait would have been
+type fakeClock struct {
+	mu     sync.Mutex
+	delays []time.Duration
+}
+
+func (fc *fakeClock) After(d time.Duration) <-chan time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.delays = append(fc.delays, d)
+	c := make(chan time.Time, 1)
+	c <- time.Time{}
+	return c
+}
+
+// blockedClock never fires
+type blockedClock struct{}
+
+func (blockedClock) After(d time.Duration) <-chan time.Time {
+	return nil
+}
+
+// stubCognito serves the given statuses in turn, then the valid JWKS
+func stubCognito(statuses ...int) (*httptest.Server, *int) {
+	var requests int
+	var mu sync.Mutex
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests <= len(statuses) {
+			w.WriteHeader(statuses[requests-1])
+			return
+		}
+		resp, _ := MockJWKSRetriever{}.RetrieveJWKS(req.Context(), "", "", ResponseMetadata{})
+		body, _ := io.ReadAll(resp.Body)
+		w.Write(body)
+	}))
+	return stub, &requests
+}
+
+func newTestRetryingJWKSRetriever(jr JWKSRetriever, policy RetryPolicy) (*RetryingJWKSRetriever, *fakeClock) {
+	fc := &fakeClock{}
+	r := NewRetryingJWKSRetriever(jr, policy)
+	r.clock = fc
+	r.random = func() float64 { return 1 }
+	return r, fc
+}
+
+var testRetryPolicy = RetryPolicy{MaxAttempts: 4, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+func TestRetryingJWKSRetriever(t *testing.T) {
+	Convey("Given a retrying retriever in front of a stand-in for Cognito", t, func() {
+		tests := []struct {
+			description      string
+			statuses         []int
+			expectedRequests int
+			expectedStatus   int
+			expectedDelays   []time.Duration
+		}{
+			{"succeeds at once", nil, 1, http.StatusOK, nil},
+			{"recovers from a server error", []int{http.StatusInternalServerError}, 2, http.StatusOK, []time.Duration{100 * time.Millisecond}},
+			{"recovers from throttling and bad gateways", []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable}, 4, http.StatusOK, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}},
+			{"gives up after the maximum attempts", []int{503, 503, 503, 503, 503}, 4, http.StatusServiceUnavailable, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}},
+			{"never retries an unknown user pool", []int{http.StatusNotFound}, 1, http.StatusNotFound, nil},
+			{"never retries a bad request", []int{http.StatusBadRequest}, 1, http.StatusBadRequest, nil},
+		}
+		for _, test := range tests {
+			Convey("When Cognito "+test.description, func() {
+				stub, requests := stubCognito(test.statuses...)
+				defer stub.Close()
+				cognito := CognitoJWKSRetriever{URLTemplate: stub.URL + "/{region}/{userPoolId}/.well-known/jwks.json"}
+				r, fc := newTestRetryingJWKSRetriever(cognito, testRetryPolicy)
+
+				resp, err := r.RetrieveJWKS(ctx, "eu-west-2", "eu-west-2_abc", ResponseMetadata{})
+
+				So(err, ShouldBeNil)
+				defer resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, test.expectedStatus)
+				So(*requests, ShouldEqual, test.expectedRequests)
+				So(fc.delays, ShouldResemble, test.expectedDelays)
+			})
+		}
+	})
+
+	Convey("Given a retrying retriever in front of a retriever with a network error", t, func() {
+		cjr := &CountingJWKSRetriever{JWKSRetriever: JWKSRetrieverHttpErr{}}
+		r, fc := newTestRetryingJWKSRetriever(cjr, testRetryPolicy)
+
+		Convey("Then the request is retried and the last error returned", func() {
+			_, err := r.RetrieveJWKS(ctx, "eu-west-2", "eu-west-2_abc", ResponseMetadata{})
+			So(err.Error(), ShouldEqual, "Http error occured whilst attempting to retrieve JWKS.")
+			So(cjr.Calls(), ShouldEqual, 4)
+			So(fc.delays, ShouldHaveLength, 3)
+		})
+	})
+
+	Convey("Given a retrying retriever in front of a retriever failing with an error", t, func() {
+		tests := []struct {
+			description string
+			err         error
+			retried     bool
+		}{
+			{"times out", newUpstreamError(context.DeadlineExceeded), true},
+			{"has its connection refused", newUpstreamError(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}), true},
+			{"has its connection reset", newUpstreamError(&net.OpError{Op: "read", Err: syscall.ECONNRESET}), true},
+			{"fails to look up a host temporarily", newUpstreamError(&net.DNSError{Name: "cognito-idp.eu-west-2.amazonaws.com", IsTemporary: true}), true},
+			{"fails in transport", errors.New("Http error occured whilst attempting to retrieve JWKS."), true},
+			{"looks up a host that does not exist", newUpstreamError(&net.DNSError{Name: "cognito-idp.xx-made-up-1.amazonaws.com", IsNotFound: true}), false},
+			{"does not trust the certificate", newUpstreamError(x509.UnknownAuthorityError{}), false},
+			{"has an invalid JWKS URL", fmt.Errorf("%w: parse error", errInvalidJWKSURL), false},
+		}
+		for _, test := range tests {
+			Convey("When it "+test.description+", check it is retried only if asking again may succeed", func() {
+				cjr := &CountingJWKSRetriever{JWKSRetriever: FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+					return nil, test.err
+				})}
+				r, _ := newTestRetryingJWKSRetriever(cjr, testRetryPolicy)
+
+				_, err := r.RetrieveJWKS(ctx, "eu-west-2", "eu-west-2_abc", ResponseMetadata{})
+
+				So(err, ShouldEqual, test.err)
+				if test.retried {
+					So(cjr.Calls(), ShouldEqual, testRetryPolicy.MaxAttempts)
+				} else {
+					So(cjr.Calls(), ShouldEqual, 1)
+				}
+			})
+		}
+	})
+
+	Convey("Given a retrying retriever in front of Cognito with an untrusted certificate, check the TLS failure is not retried", t, func() {
+		stub := httptest.NewTLSServer(http.NotFoundHandler())
+		defer stub.Close()
+		cjr := &CountingJWKSRetriever{JWKSRetriever: CognitoJWKSRetriever{URLTemplate: stub.URL + "/{region}/{userPoolId}"}}
+		r, _ := newTestRetryingJWKSRetriever(cjr, testRetryPolicy)
+
+		_, err := r.RetrieveJWKS(ctx, "eu-west-2", "eu-west-2_abc", ResponseMetadata{})
+
+		var upstreamErr *UpstreamError
+		So(errors.As(err, &upstreamErr), ShouldBeTrue)
+		So(upstreamErr.Kind, ShouldEqual, UpstreamTLSFailure)
+		So(cjr.Calls(), ShouldEqual, 1)
+	})
+
+	Convey("Given a retrying retriever in front of Cognito with a malformed URL template, check the request is not retried", t, func() {
+		cjr := &CountingJWKSRetriever{JWKSRetriever: CognitoJWKSRetriever{URLTemplate: "http://[::1/{region}/{userPoolId}"}}
+		r, _ := newTestRetryingJWKSRetriever(cjr, testRetryPolicy)
+
+		_, err := r.RetrieveJWKS(ctx, "eu-west-2", "eu-west-2_abc", ResponseMetadata{})
+
+		So(errors.Is(err, errInvalidJWKSURL), ShouldBeTrue)
+		So(cjr.Calls(), ShouldEqual, 1)
+	})
+
+	Convey("Given a retrying retriever whose caller has gone", t, func() {
+		cancelCtx, cancel := context.WithCancel(ctx)
+		cjr := &CountingJWKSRetriever{JWKSRetriever: FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+			cancel()
+			return nil, errors.New("cancelled")
+		})}
+		r, _ := newTestRetryingJWKSRetriever(cjr, testRetryPolicy)
+
+		Convey("Then the request is not retried", func() {
+			r.RetrieveJWKS(cancelCtx, "eu-west-2", "eu-west-2_abc", ResponseMetadata{})
+			So(cjr.Calls(), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given a retrying retriever whose caller goes while it waits to retry", t, func() {
+		cancelCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		r := NewRetryingJWKSRetriever(JWKSRetrieverHttpErr{}, testRetryPolicy)
+		r.clock = blockedClock{}
+
+		Convey("Then it stops waiting and returns the context's error", func() {
+			_, err := r.RetrieveJWKS(cancelCtx, "eu-west-2", "eu-west-2_abc", ResponseMetadata{})
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given a retrying retriever with retries disabled, check only one request is made", t, func() {
+		cjr := &CountingJWKSRetriever{JWKSRetriever: JWKSRetrieverHttpErr{}}
+		r, _ := newTestRetryingJWKSRetriever(cjr, RetryPolicy{MaxAttempts: 1})
+		r.RetrieveJWKS(ctx, "eu-west-2", "eu-west-2_abc", ResponseMetadata{})
+		So(cjr.Calls(), ShouldEqual, 1)
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	Convey("Given a retry policy with jitter", t, func() {
+		r := NewRetryingJWKSRetriever(nil, RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond, Jitter: 0.5})
+
+		Convey("Then with the most jitter, delays are halved", func() {
+			r.random = func() float64 { return 1 }
+			So(r.delay(1), ShouldEqual, 50*time.Millisecond)
+			So(r.delay(2), ShouldEqual, 100*time.Millisecond)
+		})
+
+		Convey("Then with no jitter, delays double up to the maximum", func() {
+			r.random = func() float64 { return 0 }
+			So(r.delay(1), ShouldEqual, 100*time.Millisecond)
+			So(r.delay(2), ShouldEqual, 200*time.Millisecond)
+			So(r.delay(3), ShouldEqual, 300*time.Millisecond)
+			So(r.delay(10), ShouldEqual, 300*time.Millisecond)
+		})
+	})
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// jwkMembers are the members of a JWK that RFC 7517 registers for every key type
+var jwkMembers = []string{"kty", "use", "key_ops", "alg", "kid", "x5u", "x5c", "x5t", "x5t#S256"}
+
+// privateMembers are the members of a JWK that RFC 7518 registers for private and symmetric keys, which this
+// service must never publish
+var privateMembers = []string{"d", "p", "q", "dp", "dq", "qi", "oth", "k"}
+
+// ktyMembers are the members, all required, of a public JWK registered for each key type this service converts, by
+// RFC 7518 and RFC 8037
+var ktyMembers = map[string][]string{
+	"RSA": {"n", "e"},
+	"EC":  {"crv", "x", "y"},
+	"OKP": {"crv", "x"},
+}
+
+// jwksDocument is a JWKS whose keys have yet to be decoded, so that one malformed key need not fail the rest
+type jwksDocument struct {
+	Keys *[]json.RawMessage `json:"keys"`
+}
+
+// decodeJwks decodes a JWKS, returning the keys that are well formed and why the others are not. A document that is
+// not JSON, not a JWKS, or in which no key is well formed, is returned as an UpstreamError, as is any malformed key
+// when cfg.AllOrNothing is set. In strict mode members that are not registered for a key's type, and members of a
+// JWKS other than keys, are malformed.
+func decodeJwks(body []byte, cfg ConversionConfig) (JWKS, []KeyError, error) {
+	if !json.Valid(body) {
+		var jwks JWKS
+		err := json.Unmarshal(body, &jwks)
+		return JWKS{}, nil, &UpstreamError{Kind: UpstreamInvalidJSON, Err: err}
+	}
+	var doc jwksDocument
+	if err := unmarshalStrict(body, &doc, cfg.StrictParsing); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			if typeErr.Field == "keys" {
+				err = errors.New("keys is not an array")
+			} else {
+				err = errors.New("JWKS is not a JSON object")
+			}
+		}
+		return JWKS{}, nil, &UpstreamError{Kind: UpstreamInvalidJWKS, Err: err}
+	}
+	if doc.Keys == nil {
+		return JWKS{}, nil, &UpstreamError{Kind: UpstreamInvalidJWKS, Err: errors.New("missing required member keys")}
+	}
+	if len(*doc.Keys) == 0 {
+		return JWKS{}, nil, &UpstreamError{Kind: UpstreamInvalidJWKS, Err: errors.New("JWKS has no keys")}
+	}
+	var jwks JWKS
+	var keyErrors []KeyError
+	for i, raw := range *doc.Keys {
+		jwk, err := decodeJwk(raw, cfg.StrictParsing)
+		if err == nil {
+			jwks.Keys = append(jwks.Keys, jwk)
+			continue
+		}
+		kid := jwk.Kid
+		if kid == "" {
+			kid = fmt.Sprintf("keys[%d]", i)
+		}
+		if cfg.AllOrNothing {
+			return JWKS{}, nil, &UpstreamError{Kind: UpstreamInvalidJWKS, Err: fmt.Errorf("key %s: %s", kid, err.Error())}
+		}
+		keyErrors = append(keyErrors, KeyError{Kid: kid, Reason: err.Error()})
+	}
+	if len(jwks.Keys) == 0 && len(keyErrors) > 0 {
+		return JWKS{}, nil, &UpstreamError{Kind: UpstreamInvalidJWKS, Err: errors.New("no key is well formed: " + joinKeyErrors(keyErrors))}
+	}
+	return jwks, keyErrors, nil
+}
+
+// decodeJwk decodes a JWK, checking that it has every member required for its key type and, in strict mode, no
+// members that are not registered for it. The kid is returned with the error if it could be decoded.
+func decodeJwk(raw json.RawMessage, strict bool) (JsonKey, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &members); err != nil {
+		return JsonKey{}, errors.New("JWK is not a JSON object")
+	}
+	var jwk JsonKey
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		var kid struct {
+			Kid string `json:"kid"`
+		}
+		json.Unmarshal(raw, &kid)
+		return JsonKey{Kid: kid.Kid}, fmt.Errorf("invalid JWK: %s", err.Error())
+	}
+	var missing []string
+	for _, name := range []string{"kty", "kid"} {
+		if _, ok := members[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return jwk, errors.New("missing required members " + strings.Join(missing, ", "))
+	}
+	required, known := ktyMembers[jwk.Kty]
+	if !known {
+		// left to the conversion to report as unsupported
+		return jwk, nil
+	}
+	for _, name := range required {
+		if _, ok := members[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return jwk, fmt.Errorf("missing required members %s for kty %s", strings.Join(missing, ", "), jwk.Kty)
+	}
+	if !strict {
+		return jwk, nil
+	}
+	var unknown []string
+	for name := range members {
+		if !contains(jwkMembers, name) && !contains(required, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return jwk, fmt.Errorf("unknown members %s for kty %s", strings.Join(unknown, ", "), jwk.Kty)
+	}
+	return jwk, nil
+}
+
+// publicMembers returns a JWK without any private members it has, unchanged if it has none
+func publicMembers(raw json.RawMessage) (json.RawMessage, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &members); err != nil {
+		return nil, err
+	}
+	stripped := false
+	for _, name := range privateMembers {
+		if _, ok := members[name]; ok {
+			delete(members, name)
+			stripped = true
+		}
+	}
+	if !stripped {
+		return append(json.RawMessage(nil), raw...), nil
+	}
+	return json.Marshal(members)
+}
+
+// unmarshalStrict decodes JSON, rejecting members that v does not have in strict mode
+func unmarshalStrict(data []byte, v interface{}, strict bool) error {
+	if !strict {
+		return json.Unmarshal(data, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDecodeJwks(t *testing.T) {
+	rsaKey := `{"kty":"RSA","kid":"rsa","alg":"RS256","use":"sig","e":"AQAB","n":"` + validJWKS.Keys[0].N + `"}`
+
+	Convey("Given a document that is not JSON, check it is reported as invalid JSON", t, func() {
+		_, _, err := decodeJwks([]byte(`{"keys":[`), ConversionConfig{})
+		var upstreamErr *UpstreamError
+		So(errors.As(err, &upstreamErr), ShouldBeTrue)
+		So(upstreamErr.Kind, ShouldEqual, UpstreamInvalidJSON)
+	})
+
+	Convey("Given JSON that is not a JWKS", t, func() {
+		tests := map[string]string{
+			`[]`:              "JWKS is not a JSON object",
+			`{}`:              "missing required member keys",
+			`{"keys":{}}`:     "keys is not an array",
+			`{"keys":[]}`:     "JWKS has no keys",
+			`{"keys":[1, 2]}`: "no key is well formed: keys[0]: JWK is not a JSON object; keys[1]: JWK is not a JSON object",
+		}
+		for body, reason := range tests {
+			Convey("When it is "+body+", check it is reported as an invalid JWKS", func() {
+				_, _, err := decodeJwks([]byte(body), ConversionConfig{})
+				So(err, ShouldResemble, &UpstreamError{Kind: UpstreamInvalidJWKS, Err: errors.New(reason)})
+			})
+		}
+	})
+
+	Convey("Given a JWKS with keys missing required members", t, func() {
+		body := `{"keys":[` + rsaKey + `,{"kty":"RSA","kid":"no-n","e":"AQAB"},{"kty":"EC","kid":"no-xy","crv":"P-256"},{"kid":"no-kty"},{"kty":"OKP","crv":"Ed25519","x":"AA"},{"kty":"RSA","kid":"bad-n","n":1,"e":"AQAB"}]}`
+
+		Convey("When it is decoded, check the well formed key is returned with why each of the others is malformed", func() {
+			jwks, keyErrors, err := decodeJwks([]byte(body), ConversionConfig{})
+			So(err, ShouldBeNil)
+			So(jwks.Keys, ShouldHaveLength, 1)
+			So(jwks.Keys[0].Kid, ShouldEqual, "rsa")
+			So(keyErrors, ShouldResemble, []KeyError{
+				{Kid: "no-n", Reason: "missing required members n for kty RSA"},
+				{Kid: "no-xy", Reason: "missing required members x, y for kty EC"},
+				{Kid: "no-kty", Reason: "missing required members kty"},
+				{Kid: "keys[4]", Reason: "missing required members kid"},
+				{Kid: "bad-n", Reason: "invalid JWK: json: cannot unmarshal number into Go struct field jsonKey.n of type string"},
+			})
+		})
+
+		Convey("When it is decoded all or nothing, check the first malformed key fails it", func() {
+			_, _, err := decodeJwks([]byte(body), ConversionConfig{AllOrNothing: true})
+			So(err, ShouldResemble, &UpstreamError{Kind: UpstreamInvalidJWKS, Err: errors.New("key no-n: missing required members n for kty RSA")})
+		})
+	})
+
+	Convey("Given a JWKS with members that are not registered", t, func() {
+		body := `{"keys":[` + rsaKey + `,{"kty":"RSA","kid":"private","e":"AQAB","n":"AQAB","d":"AQAB","p":"AQAB"}],"extra":true}`
+
+		Convey("When it is decoded, check they are ignored", func() {
+			jwks, keyErrors, err := decodeJwks([]byte(body), ConversionConfig{})
+			So(err, ShouldBeNil)
+			So(jwks.Keys, ShouldHaveLength, 2)
+			So(keyErrors, ShouldBeEmpty)
+		})
+
+		Convey("When it is decoded, check the private members of the key are left out of it as passed through", func() {
+			jwks, _, err := decodeJwks([]byte(body), ConversionConfig{})
+			So(err, ShouldBeNil)
+			b, err := json.Marshal(jwks.Keys[1])
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, `{"e":"AQAB","kid":"private","kty":"RSA","n":"AQAB"}`)
+			b, err = json.Marshal(jwks.Keys[0])
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, rsaKey)
+		})
+
+		Convey("When it is decoded strictly, check the JWKS is rejected", func() {
+			_, _, err := decodeJwks([]byte(body), ConversionConfig{StrictParsing: true})
+			So(err, ShouldResemble, &UpstreamError{Kind: UpstreamInvalidJWKS, Err: errors.New(`json: unknown field "extra"`)})
+		})
+
+		Convey("When its keys are decoded strictly, check the key with unknown members is malformed", func() {
+			body := strings.Replace(body, `,"extra":true`, "", 1)
+			jwks, keyErrors, err := decodeJwks([]byte(body), ConversionConfig{StrictParsing: true})
+			So(err, ShouldBeNil)
+			So(jwks.Keys, ShouldHaveLength, 1)
+			So(keyErrors, ShouldResemble, []KeyError{{Kid: "private", Reason: "unknown members d, p for kty RSA"}})
+		})
+	})
+}
+
+func TestReadKeysLimits(t *testing.T) {
+	Convey("Given Cognito responds with a JWKS larger than the configured maximum, check a 502 saying so is returned", t, func() {
+		cache := NewCachingJWKSRetriever(FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+			return &JWKSResponse{Body: io.NopCloser(strings.NewReader(`{"keys":[]}`)), StatusCode: http.StatusOK}, nil
+		}), CacheConfig{Conversion: ConversionConfig{MaxBodySize: 10}})
+		resp := httptest.NewRecorder()
+
+		UserPoolIdHandler(ctx, cache).ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil))
+
+		So(resp.Code, ShouldEqual, http.StatusBadGateway)
+		So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"UpstreamBodyTooLarge","description":"response from AWS Cognito is too large: body exceeds 10 bytes"}]}`)
+	})
+
+	Convey("Given Cognito responds with JSON that is not a JWKS, check a 502 saying so is returned", t, func() {
+		userPoolIdHandler := UserPoolIdHandler(ctx, FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+			return &JWKSResponse{Body: io.NopCloser(strings.NewReader(`{"message":"ok"}`)), StatusCode: http.StatusOK}, nil
+		}))
+		resp := httptest.NewRecorder()
+
+		userPoolIdHandler.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil))
+
+		So(resp.Code, ShouldEqual, http.StatusBadGateway)
+		So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"UpstreamInvalidJWKS","description":"response from AWS Cognito is not a valid JWKS: missing required member keys"}]}`)
+	})
+
+	Convey("Given Cognito responds with a JWKS holding a private key, check its private members are not served as JWK", t, func() {
+		body := `{"keys":[{"kty":"RSA","kid":"private","e":"AQAB","n":"` + validJWKS.Keys[0].N + `","d":"AQAB","p":"AQAB","q":"AQAB","dp":"AQAB","dq":"AQAB","qi":"AQAB"}]}`
+		userPoolIdHandler := UserPoolIdHandler(ctx, FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+			return &JWKSResponse{Body: io.NopCloser(strings.NewReader(body)), StatusCode: http.StatusOK}, nil
+		}))
+		resp := httptest.NewRecorder()
+
+		userPoolIdHandler.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId?format=jwk", nil))
+
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Body.String(), ShouldEqual, `{"keys":[{"e":"AQAB","kid":"private","kty":"RSA","n":"`+validJWKS.Keys[0].N+`"}]}`)
+	})
+
+	Convey("Given Cognito responds with a JWKS without keys, check a 502 saying so is returned", t, func() {
+		userPoolIdHandler := UserPoolIdHandler(ctx, FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+			return &JWKSResponse{Body: io.NopCloser(strings.NewReader(`{"keys":[]}`)), StatusCode: http.StatusOK}, nil
+		}))
+		resp := httptest.NewRecorder()
+
+		userPoolIdHandler.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil))
+
+		So(resp.Code, ShouldEqual, http.StatusBadGateway)
+		So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"UpstreamInvalidJWKS","description":"response from AWS Cognito is not a valid JWKS: JWKS has no keys"}]}`)
+	})
+
+	Convey("Given Cognito responds with a JWKS holding a malformed key, check a warning says why it was left out", t, func() {
+		body := `{"keys":[` + jsonKey(t, validJWKS.Keys[0]) + `,{"kty":"RSA","kid":"no-n","e":"AQAB"}]}`
+		userPoolIdHandler := UserPoolIdHandler(ctx, FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+			return &JWKSResponse{Body: io.NopCloser(strings.NewReader(body)), StatusCode: http.StatusOK}, nil
+		}))
+		resp := httptest.NewRecorder()
+
+		userPoolIdHandler.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil))
+
+		So(resp.Code, ShouldEqual, http.StatusOK)
+		So(resp.Header().Get("Warning"), ShouldEqual, `199 - "key no-n left out: missing required members n for kty RSA"`)
+	})
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestThumbprint(t *testing.T) {
+	Convey("Given JWKs of each supported key type, check their RFC 7638 thumbprints", t, func() {
+		tests := []struct {
+			jwk        JsonKey
+			thumbprint string
+		}{
+			// RFC 7638 section 3.1
+			{JsonKey{Kty: "RSA", E: "AQAB", Alg: "RS256", Kid: "2011-04-29", N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"}, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"},
+			// RFC 8037 appendix A.3
+			{rfc8037Key, "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"},
+		}
+		for _, test := range tests {
+			thumbprint, err := thumbprint(test.jwk)
+			So(err, ShouldBeNil)
+			So(thumbprint, ShouldEqual, test.thumbprint)
+		}
+	})
+
+	Convey("Given an EC JWK, check only its required members contribute to its thumbprint", t, func() {
+		jwk := JsonKey{Kty: "EC", Crv: "P-256", X: "f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU", Y: "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"}
+		withoutOptional, err := thumbprint(jwk)
+		So(err, ShouldBeNil)
+		jwk.Kid, jwk.Alg, jwk.Use = "ec-key", "ES256", "sig"
+		withOptional, err := thumbprint(jwk)
+		So(err, ShouldBeNil)
+		So(withOptional, ShouldEqual, withoutOptional)
+		jwk.Y = "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5aw"
+		changed, err := thumbprint(jwk)
+		So(err, ShouldBeNil)
+		So(changed, ShouldNotEqual, withoutOptional)
+	})
+
+	Convey("Given a JWK of an unsupported key type, check no thumbprint is computed", t, func() {
+		_, err := thumbprint(JsonKey{Kty: "oct"})
+		So(err, ShouldNotBeNil)
+	})
+}
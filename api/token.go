@@ -0,0 +1,228 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	b64 "encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Token verification failure codes, returned in a TokenError
+const (
+	CodeTokenMalformed       = "TokenMalformed"
+	CodeUnsupportedAlgorithm = "UnsupportedAlgorithm"
+	CodeInvalidSignature     = "InvalidSignature"
+	CodeMissingClaim         = "MissingClaim"
+	CodeTokenExpired         = "TokenExpired"
+	CodeTokenNotYetValid     = "TokenNotYetValid"
+	CodeTokenIssuedInFuture  = "TokenIssuedInFuture"
+	CodeInvalidIssuer        = "InvalidIssuer"
+)
+
+// DefaultIssuerTemplate is the iss claim of tokens issued by an AWS Cognito user pool
+const DefaultIssuerTemplate = "https://cognito-idp.{region}.amazonaws.com/{userPoolId}"
+
+// TokenError says why a token failed verification
+type TokenError struct {
+	Code   string
+	Reason string
+}
+
+func (e *TokenError) Error() string {
+	return e.Reason
+}
+
+func tokenError(code, format string, a ...interface{}) *TokenError {
+	return &TokenError{Code: code, Reason: fmt.Sprintf(format, a...)}
+}
+
+// Token is a JWT in compact serialization whose signature and claims have yet to be verified
+type Token struct {
+	Header TokenHeader
+	Claims map[string]interface{}
+
+	signingInput string
+	signature    []byte
+}
+
+// TokenHeader is the JOSE header of a Token
+type TokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// ParseToken decodes a JWT without verifying it, returning a TokenError if it is malformed
+func ParseToken(raw string) (*Token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, tokenError(CodeTokenMalformed, "token must have 3 parts, has %d", len(parts))
+	}
+	var token Token
+	if err := decodeTokenPart(parts[0], &token.Header); err != nil {
+		return nil, tokenError(CodeTokenMalformed, "error decoding header: %s", err.Error())
+	}
+	if err := decodeTokenPart(parts[1], &token.Claims); err != nil {
+		return nil, tokenError(CodeTokenMalformed, "error decoding claims: %s", err.Error())
+	}
+	signature, err := b64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, tokenError(CodeTokenMalformed, "error decoding signature")
+	}
+	if token.Header.Kid == "" {
+		return nil, tokenError(CodeTokenMalformed, "header has no kid")
+	}
+	token.signingInput = parts[0] + "." + parts[1]
+	token.signature = signature
+	return &token, nil
+}
+
+func decodeTokenPart(part string, v interface{}) error {
+	b, err := b64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// tokenAlgs are the JWS algorithms tokens can be verified with, by the key type they need
+var tokenAlgs = map[string]struct {
+	kty  string
+	hash crypto.Hash
+}{
+	"RS256": {"RSA", crypto.SHA256},
+	"RS384": {"RSA", crypto.SHA384},
+	"RS512": {"RSA", crypto.SHA512},
+	"PS256": {"RSA", crypto.SHA256},
+	"PS384": {"RSA", crypto.SHA384},
+	"PS512": {"RSA", crypto.SHA512},
+	"ES256": {"EC", crypto.SHA256},
+	"ES384": {"EC", crypto.SHA384},
+	"ES512": {"EC", crypto.SHA512},
+	"EdDSA": {"OKP", 0},
+}
+
+// ecAlgCurves are the curves the ECDSA algorithms must be used with
+var ecAlgCurves = map[string]string{"ES256": "P-256", "ES384": "P-384", "ES512": "P-521"}
+
+// VerifySignature checks the token's signature with the key, which must be of the type its alg needs and, if its
+// JWK has an alg, for the token's alg
+func (t *Token) VerifySignature(key PublicKey) error {
+	alg, ok := tokenAlgs[t.Header.Alg]
+	if !ok {
+		return tokenError(CodeUnsupportedAlgorithm, "unsupported alg %q", t.Header.Alg)
+	}
+	if alg.kty != key.Kty || (alg.kty == "EC" && ecAlgCurves[t.Header.Alg] != key.JWK.Crv) {
+		return tokenError(CodeUnsupportedAlgorithm, "alg %s cannot be used with key %s", t.Header.Alg, key.JWK.Kid)
+	}
+	if key.JWK.Alg != "" && key.JWK.Alg != t.Header.Alg {
+		return tokenError(CodeUnsupportedAlgorithm, "alg %s does not match alg %s of key %s", t.Header.Alg, key.JWK.Alg, key.JWK.Kid)
+	}
+	der, err := b64.StdEncoding.DecodeString(key.DER)
+	if err != nil {
+		return err
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return err
+	}
+	if !verifySignature(t.Header.Alg, alg.hash, pub, []byte(t.signingInput), t.signature) {
+		return tokenError(CodeInvalidSignature, "signature does not verify with key %s", key.JWK.Kid)
+	}
+	return nil
+}
+
+func verifySignature(alg string, hash crypto.Hash, pub crypto.PublicKey, signed, signature []byte) bool {
+	if hash == 0 {
+		edKey, ok := pub.(ed25519.PublicKey)
+		return ok && ed25519.Verify(edKey, signed, signature)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "PS") {
+			return rsa.VerifyPSS(key, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+		return rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	default:
+		return false
+	}
+}
+
+// ValidateClaims checks that the token was issued by the issuer, has not expired, is valid by nbf if it has one and
+// was not issued in the future, allowing leeway for clock skew
+func (t *Token) ValidateClaims(issuer string, now time.Time, leeway time.Duration) error {
+	iss, ok := t.Claims["iss"]
+	if !ok {
+		return tokenError(CodeMissingClaim, "missing required claim iss")
+	}
+	if iss != issuer {
+		return tokenError(CodeInvalidIssuer, "iss %v is not %s", iss, issuer)
+	}
+	exp, err := t.timeClaim("exp", true)
+	if err != nil {
+		return err
+	}
+	if now.After(exp.Add(leeway)) {
+		return tokenError(CodeTokenExpired, "token expired at %s", exp.Format(time.RFC3339))
+	}
+	nbf, err := t.timeClaim("nbf", false)
+	if err != nil {
+		return err
+	}
+	if !nbf.IsZero() && now.Add(leeway).Before(nbf) {
+		return tokenError(CodeTokenNotYetValid, "token is not valid before %s", nbf.Format(time.RFC3339))
+	}
+	iat, err := t.timeClaim("iat", true)
+	if err != nil {
+		return err
+	}
+	if now.Add(leeway).Before(iat) {
+		return tokenError(CodeTokenIssuedInFuture, "token was issued in the future at %s", iat.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// timeClaim returns a NumericDate claim, or the zero time if it is missing and not required
+func (t *Token) timeClaim(name string, required bool) (time.Time, error) {
+	v, ok := t.Claims[name]
+	if !ok {
+		if required {
+			return time.Time{}, tokenError(CodeMissingClaim, "missing required claim %s", name)
+		}
+		return time.Time{}, nil
+	}
+	seconds, ok := v.(float64)
+	if !ok {
+		return time.Time{}, tokenError(CodeTokenMalformed, "claim %s is not a number", name)
+	}
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9)).UTC(), nil
+}
+
+// issuerURL returns the iss of tokens issued by a user pool, from a template with {region} and {userPoolId}
+// placeholders
+func issuerURL(template, region, userPoolId string) string {
+	if template == "" {
+		template = DefaultIssuerTemplate
+	}
+	return strings.NewReplacer("{region}", region, "{userPoolId}", userPoolId).Replace(template)
+}
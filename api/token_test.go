@@ -0,0 +1,226 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	b64 "encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testSigner is a throwaway key pair that signs tokens, with the converted public key that verifies them
+type testSigner struct {
+	alg     string
+	private crypto.Signer
+	key     PublicKey
+}
+
+func newTestSigner(t *testing.T, alg, kid string) testSigner {
+	var private crypto.Signer
+	var jwk JsonKey
+	var err error
+	switch alg {
+	case "RS256", "PS256":
+		var rsaKey *rsa.PrivateKey
+		rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		private = rsaKey
+		jwk = JsonKey{Kty: "RSA", N: b64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()), E: "AQAB"}
+	case "ES256", "ES384":
+		curve, crv := elliptic.P256(), "P-256"
+		if alg == "ES384" {
+			curve, crv = elliptic.P384(), "P-384"
+		}
+		var ecKey *ecdsa.PrivateKey
+		ecKey, err = ecdsa.GenerateKey(curve, rand.Reader)
+		private = ecKey
+		size := (curve.Params().BitSize + 7) / 8
+		jwk = JsonKey{Kty: "EC", Crv: crv, X: b64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, size))), Y: b64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, size)))}
+	case "EdDSA":
+		var pub ed25519.PublicKey
+		pub, private, err = ed25519.GenerateKey(rand.Reader)
+		jwk = JsonKey{Kty: "OKP", Crv: "Ed25519", X: b64.RawURLEncoding.EncodeToString(pub)}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk.Kid, jwk.Alg, jwk.Use = kid, alg, "sig"
+	key, err := convertJwk(jwk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testSigner{alg: alg, private: private, key: key}
+}
+
+// sign returns a token with the claims signed by the key
+func (s testSigner) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(TokenHeader{Alg: s.alg, Kid: s.key.JWK.Kid, Typ: "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := b64.RawURLEncoding.EncodeToString(header) + "." + b64.RawURLEncoding.EncodeToString(payload)
+	var signature []byte
+	var err error
+	switch key := s.private.(type) {
+	case *rsa.PrivateKey:
+		digest := crypto.SHA256.New()
+		digest.Write([]byte(signingInput))
+		if s.alg == "PS256" {
+			signature, err = rsa.SignPSS(rand.Reader, key, crypto.SHA256, digest.Sum(nil), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest.Sum(nil))
+		}
+	case *ecdsa.PrivateKey:
+		hash := crypto.SHA256
+		if s.alg == "ES384" {
+			hash = crypto.SHA384
+		}
+		digest := hash.New()
+		digest.Write([]byte(signingInput))
+		var r, sig *big.Int
+		r, sig, err = ecdsa.Sign(rand.Reader, key, digest.Sum(nil))
+		size := (key.Curve.Params().BitSize + 7) / 8
+		signature = append(r.FillBytes(make([]byte, size)), sig.FillBytes(make([]byte, size))...)
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, []byte(signingInput))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + b64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestParseToken(t *testing.T) {
+	Convey("Given tokens that are malformed, check each is reported as malformed", t, func() {
+		tests := map[string]string{
+			"abc":                              "token must have 3 parts, has 1",
+			"!.e30.AA":                         "error decoding header: illegal base64 data at input byte 0",
+			"e30.!.AA":                         "error decoding claims: illegal base64 data at input byte 0",
+			"e30.e30.!":                        "error decoding signature",
+			"e30.e30.AA":                       "header has no kid",
+			"eyJhbGciOiJSUzI1NiJ9.W10.AA":      "error decoding claims: json: cannot unmarshal array into Go value of type map[string]interface {}",
+			"eyJhbGciOiJSUzI1NiJ9.e30.AA.more": "token must have 3 parts, has 4",
+		}
+		for raw, reason := range tests {
+			_, err := ParseToken(raw)
+			So(err, ShouldResemble, &TokenError{Code: CodeTokenMalformed, Reason: reason})
+		}
+	})
+
+	Convey("Given a well formed token, check its header and claims are decoded", t, func() {
+		signer := newTestSigner(t, "EdDSA", "abc")
+		token, err := ParseToken(signer.sign(t, map[string]interface{}{"sub": "user"}))
+		So(err, ShouldBeNil)
+		So(token.Header, ShouldResemble, TokenHeader{Alg: "EdDSA", Kid: "abc", Typ: "JWT"})
+		So(token.Claims, ShouldResemble, map[string]interface{}{"sub": "user"})
+	})
+}
+
+func TestTokenVerifySignature(t *testing.T) {
+	for _, alg := range []string{"RS256", "PS256", "ES256", "ES384", "EdDSA"} {
+		Convey("Given a token signed with "+alg, t, func() {
+			signer := newTestSigner(t, alg, "abc")
+			raw := signer.sign(t, map[string]interface{}{"sub": "user"})
+
+			Convey("When it is verified with its key, check the signature verifies", func() {
+				token, err := ParseToken(raw)
+				So(err, ShouldBeNil)
+				So(token.VerifySignature(signer.key), ShouldBeNil)
+			})
+
+			Convey("When it is verified with another key of the same type, check the signature does not verify", func() {
+				token, _ := ParseToken(raw)
+				So(token.VerifySignature(newTestSigner(t, alg, "abc").key), ShouldResemble, &TokenError{Code: CodeInvalidSignature, Reason: "signature does not verify with key abc"})
+			})
+
+			Convey("When its claims are tampered with, check the signature does not verify", func() {
+				parts := strings.Split(raw, ".")
+				parts[1] = b64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`))
+				token, _ := ParseToken(strings.Join(parts, "."))
+				So(token.VerifySignature(signer.key), ShouldHaveSameTypeAs, &TokenError{})
+			})
+		})
+	}
+
+	Convey("Given a token whose alg does not fit the key", t, func() {
+		rsaSigner := newTestSigner(t, "RS256", "rsa")
+
+		Convey("When the key is of another type, check the alg is unsupported", func() {
+			token, _ := ParseToken(newTestSigner(t, "ES256", "ec").sign(t, nil))
+			So(token.VerifySignature(rsaSigner.key), ShouldResemble, &TokenError{Code: CodeUnsupportedAlgorithm, Reason: "alg ES256 cannot be used with key rsa"})
+		})
+
+		Convey("When the key is on another curve, check the alg is unsupported", func() {
+			token, _ := ParseToken(newTestSigner(t, "ES256", "ec").sign(t, nil))
+			So(token.VerifySignature(newTestSigner(t, "ES384", "ec384").key), ShouldResemble, &TokenError{Code: CodeUnsupportedAlgorithm, Reason: "alg ES256 cannot be used with key ec384"})
+		})
+
+		Convey("When the key's JWK has another alg, check the alg is unsupported", func() {
+			token, _ := ParseToken(newTestSigner(t, "PS256", "ps").sign(t, nil))
+			So(token.VerifySignature(rsaSigner.key), ShouldResemble, &TokenError{Code: CodeUnsupportedAlgorithm, Reason: "alg PS256 does not match alg RS256 of key rsa"})
+		})
+
+		Convey("When the alg is none, check it is unsupported", func() {
+			token, _ := ParseToken("eyJhbGciOiJub25lIiwia2lkIjoicnNhIn0.e30.")
+			So(token.VerifySignature(rsaSigner.key), ShouldResemble, &TokenError{Code: CodeUnsupportedAlgorithm, Reason: `unsupported alg "none"`})
+		})
+	})
+}
+
+func TestTokenValidateClaims(t *testing.T) {
+	issuer := issuerURL("", "eu-west-2", "eu-west-2_abc")
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	claims := func(overrides map[string]interface{}) *Token {
+		token := &Token{Claims: map[string]interface{}{
+			"iss": issuer,
+			"iat": float64(now.Add(-time.Minute).Unix()),
+			"exp": float64(now.Add(time.Hour).Unix()),
+		}}
+		for name, value := range overrides {
+			if value == nil {
+				delete(token.Claims, name)
+				continue
+			}
+			token.Claims[name] = value
+		}
+		return token
+	}
+
+	Convey("Given the Cognito issuer of a user pool, check it is its URL", t, func() {
+		So(issuer, ShouldEqual, "https://cognito-idp.eu-west-2.amazonaws.com/eu-west-2_abc")
+	})
+
+	Convey("Given a token with valid claims, check they validate", t, func() {
+		So(claims(nil).ValidateClaims(issuer, now, 0), ShouldBeNil)
+		So(claims(map[string]interface{}{"nbf": float64(now.Unix())}).ValidateClaims(issuer, now, 0), ShouldBeNil)
+	})
+
+	Convey("Given tokens with invalid claims, check each fails with the reason", t, func() {
+		tests := []struct {
+			overrides map[string]interface{}
+			err       *TokenError
+		}{
+			{map[string]interface{}{"iss": nil}, &TokenError{Code: CodeMissingClaim, Reason: "missing required claim iss"}},
+			{map[string]interface{}{"iss": "https://cognito-idp.eu-west-2.amazonaws.com/eu-west-2_xyz"}, &TokenError{Code: CodeInvalidIssuer, Reason: "iss https://cognito-idp.eu-west-2.amazonaws.com/eu-west-2_xyz is not " + issuer}},
+			{map[string]interface{}{"exp": nil}, &TokenError{Code: CodeMissingClaim, Reason: "missing required claim exp"}},
+			{map[string]interface{}{"exp": "tomorrow"}, &TokenError{Code: CodeTokenMalformed, Reason: "claim exp is not a number"}},
+			{map[string]interface{}{"exp": float64(now.Add(-time.Second).Unix())}, &TokenError{Code: CodeTokenExpired, Reason: "token expired at 2021-10-01T11:59:59Z"}},
+			{map[string]interface{}{"nbf": float64(now.Add(time.Second).Unix())}, &TokenError{Code: CodeTokenNotYetValid, Reason: "token is not valid before 2021-10-01T12:00:01Z"}},
+			{map[string]interface{}{"iat": nil}, &TokenError{Code: CodeMissingClaim, Reason: "missing required claim iat"}},
+			{map[string]interface{}{"iat": float64(now.Add(time.Second).Unix())}, &TokenError{Code: CodeTokenIssuedInFuture, Reason: "token was issued in the future at 2021-10-01T12:00:01Z"}},
+		}
+		for _, test := range tests {
+			So(claims(test.overrides).ValidateClaims(issuer, now, 0), ShouldResemble, test.err)
+		}
+	})
+
+	Convey("Given a token that expired within the leeway, check it validates", t, func() {
+		So(claims(map[string]interface{}{"exp": float64(now.Add(-time.Second).Unix())}).ValidateClaims(issuer, now, time.Minute), ShouldBeNil)
+	})
+}
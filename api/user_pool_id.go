@@ -2,6 +2,9 @@ package api
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/x509"
 	b64 "encoding/base64"
@@ -13,6 +16,9 @@ import (
 	"log"
 	"math/big"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
@@ -24,90 +30,292 @@ type JsonKey struct {
 	Kty string `json:"kty"`
 	N   string `json:"n"`
 	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	// X5c is the certificate chain of the key, leaf first, each certificate base64 encoded DER
+	X5c     []string `json:"x5c,omitempty"`
+	X5t     string   `json:"x5t,omitempty"`
+	X5tS256 string   `json:"x5t#S256,omitempty"`
+
+	// raw is the JWK as retrieved, members this service does not use included but private members left out, so that it
+	// can be passed through
+	raw json.RawMessage
+}
+
+// UnmarshalJSON decodes a JWK, keeping it as retrieved without its private members
+func (k *JsonKey) UnmarshalJSON(b []byte) error {
+	type jsonKey JsonKey
+	if err := json.Unmarshal(b, (*jsonKey)(k)); err != nil {
+		return err
+	}
+	raw, err := publicMembers(b)
+	if err != nil {
+		return err
+	}
+	k.raw = raw
+	return nil
+}
+
+// MarshalJSON encodes a JWK as it was retrieved, if it was
+func (k JsonKey) MarshalJSON() ([]byte, error) {
+	if k.raw != nil {
+		return k.raw, nil
+	}
+	type jsonKey JsonKey
+	return json.Marshal(jsonKey(k))
 }
 
 type JWKS struct {
 	Keys []JsonKey `json:"keys"`
 }
 
-type JWKSRetriever interface {
-	RetrieveJWKS(region, userPoolId string) (io.ReadCloser, int, error)
+// PublicKey is a public key converted from a JWK
+type PublicKey struct {
+	// Kty is the JWK key type, which tells consumers which verifier the key is for
+	Kty string
+	// DER is the base64 encoded PKIX DER of the key
+	DER string
+	// Bits is the size of the key: the modulus of an RSA key, the curve of an EC or OKP key
+	Bits int
+	// Thumbprint is the RFC 7638 SHA-256 thumbprint of the JWK
+	Thumbprint string
+	// Certificates is the x5c certificate chain of the key, leaf first, if the JWK has one
+	Certificates []*x509.Certificate
+	// JWK is the key as retrieved
+	JWK JsonKey
 }
-type CognitoJWKSRetriever struct{}
 
-func (cjr CognitoJWKSRetriever) RetrieveJWKS(region, userPoolId string) (io.ReadCloser, int, error) {
-	cognitoUrl := fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s/.well-known/jwks.json", region, userPoolId)
-	resp, err := http.Get(cognitoUrl)
-	if err != nil {
-		return nil, resp.StatusCode, errors.New("an error occurred whilst requesting JWKS from AWS Cognito")
-	}
-	return resp.Body, resp.StatusCode, nil
+// ConversionConfig controls how a JWKS is converted into public keys
+type ConversionConfig struct {
+	// MaxBodySize, when greater than zero, is the largest JWKS read in bytes, instead of DefaultMaxJWKSBodySize
+	MaxBodySize int64
+	// StrictParsing rejects keys with members not registered for their key type, and JWKS with members other than keys
+	StrictParsing bool
+	// AllOrNothing fails a JWKS in which any key cannot be decoded or converted. Otherwise the keys that can be are
+	// served.
+	AllOrNothing bool
+	// StrictKids rejects a JWKS in which different keys share a kid. Otherwise the last of them is served.
+	StrictKids bool
+	// Policy is the key policy every converted key must meet
+	Policy KeyPolicy
+	// CARoots, when set, are the CA certificates that the x5c certificate chain of a key, if it has one, must lead to
+	CARoots *x509.CertPool
+}
+
+// KidCollisionError is returned, in strict mode, when different keys in a JWKS share a kid
+type KidCollisionError struct {
+	Kids []string
+}
+
+func (e *KidCollisionError) Error() string {
+	return "JWKS has different keys with the same kid: " + strings.Join(e.Kids, ", ")
+}
+
+// DefaultMaxJWKSBodySize is the largest JWKS read from Cognito, whose user pools hold only a handful of keys, unless
+// ConversionConfig sets another
+const DefaultMaxJWKSBodySize = 1 << 20
+
+// KeysRetriever is implemented by JWKSRetrievers that can supply a user pool's already converted
+// public keys, such as CachingJWKSRetriever, letting UserPoolIdHandler skip the request and conversion
+type KeysRetriever interface {
+	RetrieveKeys(ctx context.Context, region, userPoolId string) (KeySet, error)
 }
 
 func UserPoolIdHandler(ctx context.Context, jr JWKSRetriever) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		region := mux.Vars(req)["region"]
-		userPoolId := mux.Vars(req)["userPoolId"]
-		jsonJwks, statusCode, err := jr.RetrieveJWKS(region, userPoolId)
-		if err != nil {
-			log.Println(err.Error())
-			jsonResponse, err := json.Marshal(err.Error())
-			if err != nil {
-				log.Printf("Failed to convert error message into json.\nError:%s\n", err.Error())
-			}
-			w.Write(jsonResponse)
+		vars, ok := pathVars(w, req, "region", "userPoolId")
+		if !ok {
 			return
 		}
-		if statusCode == 404 {
-			errMessage := fmt.Sprintf("User pool %s in region %s not found. Try changing the region or user pool ID.", userPoolId, region)
-			log.Println(errMessage)
-			jsonResponse, err := json.Marshal(errMessage)
-			if err != nil {
-				log.Printf("Failed to convert error message into json.\nError:%s\n", err.Error())
-			}
-			w.Write(jsonResponse)
+		region, userPoolId := vars[0], vars[1]
+		w.Header().Set("Vary", "Accept")
+		format, err := negotiateFormat(req)
+		if err != nil {
+			writeFormatError(w, err)
 			return
 		}
-		body, err := ioutil.ReadAll(jsonJwks)
+		keySet, err := retrieveKeys(req.Context(), jr, region, userPoolId)
 		if err != nil {
-			log.Println(err.Error())
+			writeRetrievalError(w, err, region, userPoolId)
 			return
 		}
-		var jwks JWKS
-		json.Unmarshal(body, &jwks)
-		jsonResponse, err := convertJwksToRsaJsonResponse(jwks)
+		setFreshnessHeaders(w.Header(), keySet)
+		setKeyInfoHeaders(w.Header(), keySet.Keys)
+		writeKeys(w, format, keySet.Keys)
+	}
+}
+
+// setFreshnessHeaders adds the Age of the keys, a Warning that they are stale if they could not be refreshed and
+// a Warning for each key left out, saying why
+func setFreshnessHeaders(h http.Header, keySet KeySet) {
+	h.Set("Age", strconv.Itoa(int(keySet.Age.Seconds())))
+	if keySet.Stale {
+		h.Add("Warning", `110 - "Response is Stale"`)
+	}
+	for _, keyErr := range keySet.KeyErrors {
+		h.Add("Warning", "199 - "+strconv.Quote("key "+keyErr.Kid+" left out: "+keyErr.Reason))
+	}
+}
+
+// setKeyInfoHeaders adds a Key-Info header per key giving its type and thumbprint, as the v1 response body holds
+// only the key
+func setKeyInfoHeaders(h http.Header, keys map[string]PublicKey) {
+	for _, key := range sortedKeys(keys) {
+		h.Add("Key-Info", fmt.Sprintf("kid=%s; kty=%s; thumbprint=%s", strconv.Quote(key.JWK.Kid), strconv.Quote(key.Kty), strconv.Quote(key.Thumbprint)))
+	}
+}
+
+// pathVars returns the named variables of a request's path unescaped, as routes are matched on the encoded path, or
+// writes a 400 and returns false if one of them is not a valid path segment
+func pathVars(w http.ResponseWriter, req *http.Request, names ...string) ([]string, bool) {
+	vars := make([]string, len(names))
+	for i, name := range names {
+		value, err := url.PathUnescape(mux.Vars(req)[name])
 		if err != nil {
-			jsonResponse, err := json.Marshal("Failed to retrieve RSA public key")
-			if err != nil {
-				log.Printf("Failed to convert error message into json.\nError:%s\n", err.Error())
-			}
-			w.Write(jsonResponse)
-			return
+			writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest, name+" is not a valid path segment: "+err.Error())
+			return nil, false
 		}
-		w.Write(jsonResponse)
+		vars[i] = value
+	}
+	return vars, true
+}
+
+// retrieveKeys returns the public keys for a user pool, from the retriever's own store if it keeps one
+func retrieveKeys(ctx context.Context, jr JWKSRetriever, region, userPoolId string) (KeySet, error) {
+	if kr, ok := jr.(KeysRetriever); ok {
+		return kr.RetrieveKeys(ctx, region, userPoolId)
+	}
+	return fetchKeys(ctx, jr, region, userPoolId, ConversionConfig{})
+}
+
+// fetchKeys requests a user pool's JWKS and converts it into public keys
+func fetchKeys(ctx context.Context, jr JWKSRetriever, region, userPoolId string, cfg ConversionConfig) (KeySet, error) {
+	resp, err := jr.RetrieveJWKS(ctx, region, userPoolId, ResponseMetadata{})
+	if err != nil {
+		return KeySet{}, err
+	}
+	defer resp.Body.Close()
+	return readKeys(resp, cfg)
+}
+
+// readKeys decodes the JWKS in a response and converts it into public keys, leaving out those that are malformed or
+// fail the key policy
+func readKeys(resp *JWKSResponse, cfg ConversionConfig) (KeySet, error) {
+	if resp.StatusCode == http.StatusNotFound {
+		return KeySet{}, ErrUserPoolNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return KeySet{}, &UpstreamError{Kind: UpstreamUnexpectedStatus, Err: fmt.Errorf("status %d", resp.StatusCode)}
+	}
+	maxBodySize := cfg.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxJWKSBodySize
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		return KeySet{}, newUpstreamError(err)
+	}
+	if int64(len(body)) > maxBodySize {
+		return KeySet{}, &UpstreamError{Kind: UpstreamBodyTooLarge, Err: fmt.Errorf("body exceeds %d bytes", maxBodySize)}
+	}
+	jwks, malformed, err := decodeJwks(body, cfg)
+	if err != nil {
+		return KeySet{}, err
+	}
+	keySet, err := convertJwks(jwks, cfg)
+	var collision *KidCollisionError
+	if errors.As(err, &collision) {
+		return KeySet{}, err
+	}
+	if err != nil {
+		return KeySet{}, ErrKeyConversion
 	}
+	keySet.KeyErrors = append(malformed, keySet.KeyErrors...)
+	return cfg.Policy.apply(keySet)
 }
 
-func convertJwksToRsaJsonResponse(jwks JWKS) ([]byte, error) {
+// convertJwks converts every key of a JWKS. Unless cfg.AllOrNothing is set, keys that cannot be converted are left
+// out, saying why, and only a JWKS in which no key can be converted fails.
+func convertJwks(jwks JWKS, cfg ConversionConfig) (KeySet, error) {
 	if len(jwks.Keys) == 0 {
 		log.Println("Empty JWKS")
-		return nil, errors.New("empty JWKS")
+		return KeySet{}, errors.New("empty JWKS")
 	}
-	var response = make(map[string]string)
-	var err error
+	var response = make(map[string]PublicKey)
+	var keyErrors []KeyError
+	var collisions []string
 	for _, jwk := range jwks.Keys {
-		response[jwk.Kid], err = convertJwkToRsa(jwk)
+		key, err := convertJwk(jwk)
+		if err == nil && cfg.CARoots != nil && key.Certificates != nil {
+			err = verifyCertificateChain(key.Certificates, cfg.CARoots)
+		}
 		if err != nil {
-			log.Println("Failed to retrieve RSA public key")
-			return nil, err
+			log.Printf("Failed to retrieve public key %s.\nError:%s\n", jwk.Kid, err.Error())
+			if cfg.AllOrNothing {
+				return KeySet{}, err
+			}
+			keyErrors = append(keyErrors, KeyError{Kid: jwk.Kid, Reason: err.Error()})
+			continue
 		}
+		// the same key listed twice is harmless, different keys with the same kid make the kid ambiguous
+		if prev, ok := response[jwk.Kid]; ok && prev.Thumbprint != key.Thumbprint {
+			log.Printf("Keys with thumbprints %s and %s have the same kid %q\n", prev.Thumbprint, key.Thumbprint, jwk.Kid)
+			collisions = append(collisions, jwk.Kid)
+		}
+		response[jwk.Kid] = key
+	}
+	if cfg.StrictKids && len(collisions) > 0 {
+		return KeySet{}, &KidCollisionError{Kids: collisions}
+	}
+	if len(response) == 0 {
+		return KeySet{}, errors.New("no key in the JWKS could be converted")
+	}
+	return KeySet{Keys: response, KeyErrors: keyErrors}, nil
+}
+
+// convertJwk converts a JWK into a public key according to its key type
+func convertJwk(jwk JsonKey) (PublicKey, error) {
+	var der string
+	var err error
+	switch jwk.Kty {
+	case "RSA":
+		der, err = convertJwkToRsa(jwk)
+	case "EC":
+		der, err = convertJwkToEc(jwk)
+	case "OKP":
+		der, err = convertJwkToOkp(jwk)
+	default:
+		log.Println("unsupported key type:", jwk.Kty)
+		return PublicKey{}, errors.New("unsupported key type. Must be RSA, EC or OKP key")
+	}
+	if err != nil {
+		return PublicKey{}, err
 	}
-	jsonResponse, err := json.Marshal(response)
+	tp, err := thumbprint(jwk)
 	if err != nil {
-		log.Printf("Failed to convert Response object into json.\nError:%s\n", err.Error())
-		return nil, err
+		return PublicKey{}, err
+	}
+	chain, err := parseCertificateChain(jwk, der)
+	if err != nil {
+		return PublicKey{}, err
+	}
+	return PublicKey{Kty: jwk.Kty, DER: der, Bits: keyBits(jwk), Thumbprint: tp, Certificates: chain, JWK: jwk}, nil
+}
+
+// keyBits returns the size of a JWK that has already been converted
+func keyBits(jwk JsonKey) int {
+	switch jwk.Kty {
+	case "RSA":
+		nb, _ := b64.RawURLEncoding.DecodeString(jwk.N)
+		return new(big.Int).SetBytes(nb).BitLen()
+	case "EC":
+		return ecCurves[jwk.Crv].Params().BitSize
+	case "OKP":
+		return 8 * ed25519.PublicKeySize
+	default:
+		return 0
 	}
-	return jsonResponse, nil
 }
 
 func convertJwkToRsa(jwk JsonKey) (string, error) {
@@ -123,14 +331,9 @@ func convertJwkToRsa(jwk JsonKey) (string, error) {
 		return "", errors.New("error decoding JWK")
 	}
 
-	e := 0
-	// The default exponent is usually 65537, so just compare the
-	// base64 for [1,0,1] or [0,1,0,1]
-	if jwk.E == "AQAB" || jwk.E == "AAEAAQ" {
-		e = 65537
-	} else {
-		// need to decode "e" as a big-endian int
-		log.Println("need to decode e:", jwk.E)
+	e, err := decodeExponent(jwk.E)
+	if err != nil {
+		log.Println("unable to decode e:", jwk.E, err)
 		return "", errors.New("unexpected exponent: unable to decode JWK")
 	}
 
@@ -150,3 +353,101 @@ func convertJwkToRsa(jwk JsonKey) (string, error) {
 	}
 	return b64.StdEncoding.EncodeToString(der), nil
 }
+
+// ecCurves are the curves EC keys may use, by JWK crv
+var ecCurves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+func convertJwkToEc(jwk JsonKey) (string, error) {
+	if jwk.Kty != "EC" {
+		log.Println("unsupported key type:", jwk.Kty)
+		return "", errors.New("unsupported key type. Must be ec key")
+	}
+	curve, ok := ecCurves[jwk.Crv]
+	if !ok {
+		log.Println("unsupported curve:", jwk.Crv)
+		return "", errors.New("unsupported curve. Must be P-256, P-384 or P-521")
+	}
+
+	// x and y must be the full size of a coordinate on the curve, leading zeros included
+	size := (curve.Params().BitSize + 7) / 8
+	xb, err := b64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil || len(xb) != size {
+		log.Println("unable to decode x:", jwk.X, err)
+		return "", errors.New("error decoding JWK")
+	}
+	yb, err := b64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil || len(yb) != size {
+		log.Println("unable to decode y:", jwk.Y, err)
+		return "", errors.New("error decoding JWK")
+	}
+
+	pk := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(xb),
+		Y:     new(big.Int).SetBytes(yb),
+	}
+	if !curve.IsOnCurve(pk.X, pk.Y) {
+		log.Println("point is not on curve:", jwk.Crv)
+		return "", errors.New("invalid JWK: point is not on curve")
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pk)
+	if err != nil {
+		log.Println("error writing EC public key to out")
+		return "", errors.New("error writing EC public key to out")
+	}
+	return b64.StdEncoding.EncodeToString(der), nil
+}
+
+// convertJwkToOkp converts an RFC 8037 octet key pair JWK. Only Ed25519 is supported, being the only OKP curve
+// used for signing that crypto/x509 can marshal.
+func convertJwkToOkp(jwk JsonKey) (string, error) {
+	if jwk.Kty != "OKP" {
+		log.Println("unsupported key type:", jwk.Kty)
+		return "", errors.New("unsupported key type. Must be okp key")
+	}
+	if jwk.Crv != "Ed25519" {
+		log.Println("unsupported curve:", jwk.Crv)
+		return "", errors.New("unsupported curve. Must be Ed25519")
+	}
+
+	xb, err := b64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil || len(xb) != ed25519.PublicKeySize {
+		log.Println("unable to decode x:", jwk.X, err)
+		return "", errors.New("error decoding JWK")
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(ed25519.PublicKey(xb))
+	if err != nil {
+		log.Println("error writing Ed25519 public key to out")
+		return "", errors.New("error writing Ed25519 public key to out")
+	}
+	return b64.StdEncoding.EncodeToString(der), nil
+}
+
+// decodeExponent decodes the base64url encoded, big-endian RSA public exponent of a JWK. Leading zero octets are
+// tolerated. The exponent must be odd, at least 3 and fit in the 31 bits crypto/rsa will verify with.
+func decodeExponent(e string) (int, error) {
+	eb, err := b64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return 0, err
+	}
+	if len(eb) == 0 {
+		return 0, errors.New("exponent is empty")
+	}
+	exponent := new(big.Int).SetBytes(eb)
+	if exponent.BitLen() > 31 {
+		return 0, errors.New("exponent is too large")
+	}
+	if exponent.Int64() < 3 {
+		return 0, errors.New("exponent is less than 3")
+	}
+	if exponent.Bit(0) == 0 {
+		return 0, errors.New("exponent is even")
+	}
+	return int(exponent.Int64()), nil
+}
@@ -1,13 +1,25 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	b64 "encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -33,20 +45,136 @@ var validJWKS = JWKS{
 	},
 }
 
-func TestConvertJwksToRsaJsonResponse(t *testing.T) {
+func TestConvertJwks(t *testing.T) {
 	Convey("Enter a valid JWKS - check expected response", t, func() {
-		response, err := convertJwksToRsaJsonResponse(validJWKS)
-		So(response, ShouldNotResemble, nil)
+		response, err := convertJwks(validJWKS, ConversionConfig{})
 		So(err, ShouldEqual, nil)
+		So(response.Keys, ShouldHaveLength, len(validJWKS.Keys))
+		for _, jwk := range validJWKS.Keys {
+			So(response.Keys[jwk.Kid].JWK.Kid, ShouldEqual, jwk.Kid)
+		}
+		So(response.KeyErrors, ShouldBeEmpty)
 	})
 	Convey("Enter an empty JWKS - check expected error is returned", t, func() {
 		emptyJWKS := JWKS{}
-		response, err := convertJwksToRsaJsonResponse(emptyJWKS)
-		So(response, ShouldEqual, nil)
+		response, err := convertJwks(emptyJWKS, ConversionConfig{})
+		So(response, ShouldResemble, KeySet{})
 		So(err.Error(), ShouldEqual, "empty JWKS")
 	})
 }
 
+func TestConvertJwksKidCollisions(t *testing.T) {
+	ecKey := JsonKey{Kty: "EC", Kid: validJWKS.Keys[0].Kid, Crv: "P-256", X: "f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU", Y: "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"}
+
+	Convey("Given a JWKS listing the same key twice, check it is not a collision even in strict mode", t, func() {
+		keySet, err := convertJwks(JWKS{Keys: []JsonKey{validJWKS.Keys[0], validJWKS.Keys[0]}}, ConversionConfig{StrictKids: true})
+		So(err, ShouldBeNil)
+		So(keySet.Keys, ShouldHaveLength, 1)
+	})
+
+	Convey("Given a JWKS with different keys sharing a kid", t, func() {
+		jwks := JWKS{Keys: []JsonKey{validJWKS.Keys[0], ecKey}}
+
+		Convey("When not in strict mode, check the last key is served", func() {
+			keySet, err := convertJwks(jwks, ConversionConfig{})
+			So(err, ShouldBeNil)
+			So(keySet.Keys, ShouldHaveLength, 1)
+			So(keySet.Keys[ecKey.Kid].Kty, ShouldEqual, "EC")
+		})
+
+		Convey("When in strict mode, check the JWKS is rejected naming the kid", func() {
+			keySet, err := convertJwks(jwks, ConversionConfig{StrictKids: true})
+			So(keySet.Keys, ShouldBeNil)
+			So(err, ShouldResemble, &KidCollisionError{Kids: []string{ecKey.Kid}})
+			So(err.Error(), ShouldEqual, "JWKS has different keys with the same kid: j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8=")
+		})
+
+		Convey("When it is served through a cache in strict mode, check a 502 naming the kid is returned", func() {
+			body, _ := json.Marshal(jwks)
+			cache := NewCachingJWKSRetriever(FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+				return &JWKSResponse{Body: io.NopCloser(bytes.NewReader(body)), StatusCode: http.StatusOK}, nil
+			}), CacheConfig{TTL: time.Minute, Conversion: ConversionConfig{StrictKids: true}})
+			resp := httptest.NewRecorder()
+
+			UserPoolIdHandler(ctx, cache).ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil))
+
+			So(resp.Code, ShouldEqual, http.StatusBadGateway)
+			So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"UpstreamDuplicateKid","description":"The JWKS from AWS Cognito has different keys with the same kid: j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8="}]}`)
+		})
+
+		Convey("When a response holding it is read in strict mode, check the collision is returned rather than a conversion failure", func() {
+			body, _ := json.Marshal(jwks)
+			keySet, err := readKeys(&JWKSResponse{Body: io.NopCloser(bytes.NewReader(body)), StatusCode: http.StatusOK}, ConversionConfig{StrictKids: true})
+			So(keySet.Keys, ShouldBeNil)
+			var collision *KidCollisionError
+			So(errors.As(err, &collision), ShouldBeTrue)
+		})
+	})
+}
+
+func TestConvertJwksPartialSuccess(t *testing.T) {
+	Convey("Given a JWKS with a key that cannot be converted", t, func() {
+		badKey := JsonKey{Kty: "EC", Kid: "bad", Crv: "P-192", X: "AA", Y: "AA"}
+		jwks := JWKS{Keys: []JsonKey{validJWKS.Keys[0], badKey}}
+
+		Convey("When it is converted, check the other keys are returned with the reason the key was left out", func() {
+			keySet, err := convertJwks(jwks, ConversionConfig{})
+			So(err, ShouldBeNil)
+			So(keySet.Keys, ShouldHaveLength, 1)
+			So(keySet.Keys, ShouldContainKey, validJWKS.Keys[0].Kid)
+			So(keySet.KeyErrors, ShouldResemble, []KeyError{{Kid: "bad", Reason: "unsupported curve. Must be P-256, P-384 or P-521"}})
+		})
+
+		Convey("When it is converted all or nothing, check the conversion fails", func() {
+			keySet, err := convertJwks(jwks, ConversionConfig{AllOrNothing: true})
+			So(keySet.Keys, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When it is converted with keys that fail the key policy, check both reasons are reported in kid order", func() {
+			jwks.Keys = append(jwks.Keys, rsaJWKWithBits("a-weak", 512))
+			body, _ := json.Marshal(jwks)
+			keySet, err := readKeys(&JWKSResponse{Body: io.NopCloser(bytes.NewReader(body)), StatusCode: http.StatusOK}, ConversionConfig{Policy: KeyPolicy{MinRSABits: 2048}})
+			So(err, ShouldBeNil)
+			So(keySet.Keys, ShouldHaveLength, 1)
+			So(keySet.KeyErrors, ShouldResemble, []KeyError{
+				{Kid: "a-weak", Reason: "RSA modulus of 512 bits is shorter than the minimum of 2048"},
+				{Kid: "bad", Reason: "unsupported curve. Must be P-256, P-384 or P-521"},
+			})
+		})
+
+		Convey("When it is served, check a warning says why the key was left out", func() {
+			body, _ := json.Marshal(jwks)
+			retriever := FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+				return &JWKSResponse{Body: io.NopCloser(bytes.NewReader(body)), StatusCode: http.StatusOK}, nil
+			})
+			resp := httptest.NewRecorder()
+			UserPoolIdHandler(ctx, NewCachingJWKSRetriever(retriever, CacheConfig{})).ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil))
+
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get("Warning"), ShouldEqual, `199 - "key bad left out: unsupported curve. Must be P-256, P-384 or P-521"`)
+			So(resp.Body.String(), ShouldContainSubstring, validJWKS.Keys[0].Kid)
+		})
+
+		Convey("When it is served all or nothing, check a 500 is returned", func() {
+			body, _ := json.Marshal(jwks)
+			retriever := FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+				return &JWKSResponse{Body: io.NopCloser(bytes.NewReader(body)), StatusCode: http.StatusOK}, nil
+			})
+			resp := httptest.NewRecorder()
+			UserPoolIdHandler(ctx, NewCachingJWKSRetriever(retriever, CacheConfig{Conversion: ConversionConfig{AllOrNothing: true}})).ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil))
+
+			So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+		})
+	})
+
+	Convey("Given a JWKS in which no key can be converted, check the conversion fails", t, func() {
+		keySet, err := convertJwks(JWKS{Keys: []JsonKey{{Kty: "oct", Kid: "bad"}}}, ConversionConfig{})
+		So(keySet.Keys, ShouldBeNil)
+		So(err, ShouldNotBeNil)
+	})
+}
+
 func TestConvertJwkToRsa(t *testing.T) {
 	Convey("Enter a valid JWK - check expected response", t, func() {
 		response, err := convertJwkToRsa(validJWKS.Keys[0])
@@ -74,36 +202,210 @@ func TestConvertJwkToRsa(t *testing.T) {
 		So(err.Error(), ShouldEqual, "unexpected exponent: unable to decode JWK")
 		validJWKS.Keys[0].E = "AQAB"
 	})
+	Convey("Enter a JWK with an exponent other than 65537 - check it is converted", t, func() {
+		validJWKS.Keys[0].E = "Aw"
+		response, err := convertJwkToRsa(validJWKS.Keys[0])
+		So(err, ShouldBeNil)
+		der, _ := b64.StdEncoding.DecodeString(response)
+		pk, err := x509.ParsePKIXPublicKey(der)
+		So(err, ShouldBeNil)
+		So(pk.(*rsa.PublicKey).E, ShouldEqual, 3)
+		validJWKS.Keys[0].E = "AQAB"
+	})
+}
+
+// ecJWK returns the JWK of a newly generated EC key on the curve
+func ecJWK(crv string, curve elliptic.Curve) JsonKey {
+	pk, _ := ecdsa.GenerateKey(curve, rand.Reader)
+	size := (curve.Params().BitSize + 7) / 8
+	return JsonKey{
+		Kty: "EC",
+		Kid: crv,
+		Crv: crv,
+		X:   b64.RawURLEncoding.EncodeToString(pk.X.FillBytes(make([]byte, size))),
+		Y:   b64.RawURLEncoding.EncodeToString(pk.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+func TestConvertJwkToEc(t *testing.T) {
+	Convey("Given EC JWKs on each supported curve, check they are converted to PKIX DER of a key on that curve", t, func() {
+		tests := []struct {
+			jwk   JsonKey
+			curve elliptic.Curve
+		}{
+			// RFC 7515 appendix A.3
+			{JsonKey{Kty: "EC", Crv: "P-256", X: "f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU", Y: "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"}, elliptic.P256()},
+			// RFC 7520 section 3.1
+			{JsonKey{Kty: "EC", Crv: "P-521", X: "AHKZLLOsCOzz5cY97ewNUajB957y-C-U88c3v13nmGZx6sYl_oJXu9A5RkTKqjqvjyekWF-7ytDyRXYgCF5cj0Kt", Y: "AdymlHvOiLxXkEhayXQnNCvDX4h9htZaCJN34kfmC6pV5OhQHiraVySsUdaQkAgDPrwQrJmbnX9cwlGfP-HqHZR1"}, elliptic.P521()},
+			{ecJWK("P-256", elliptic.P256()), elliptic.P256()},
+			{ecJWK("P-384", elliptic.P384()), elliptic.P384()},
+			{ecJWK("P-521", elliptic.P521()), elliptic.P521()},
+		}
+		for _, test := range tests {
+			response, err := convertJwkToEc(test.jwk)
+			So(err, ShouldBeNil)
+			der, _ := b64.StdEncoding.DecodeString(response)
+			pk, err := x509.ParsePKIXPublicKey(der)
+			So(err, ShouldBeNil)
+			So(pk.(*ecdsa.PublicKey).Curve, ShouldEqual, test.curve)
+			x, _ := b64.RawURLEncoding.DecodeString(test.jwk.X)
+			So(pk.(*ecdsa.PublicKey).X.Bytes(), ShouldResemble, new(big.Int).SetBytes(x).Bytes())
+		}
+	})
+
+	Convey("Given invalid EC JWKs, check they are rejected", t, func() {
+		valid := JsonKey{Kty: "EC", Crv: "P-256", X: "f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU", Y: "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"}
+		tests := []struct {
+			modify func(jwk *JsonKey)
+			err    string
+		}{
+			{func(jwk *JsonKey) { jwk.Kty = "RSA" }, "unsupported key type. Must be ec key"},
+			{func(jwk *JsonKey) { jwk.Crv = "secp256k1" }, "unsupported curve. Must be P-256, P-384 or P-521"},
+			{func(jwk *JsonKey) { jwk.Crv = "P-384" }, "error decoding JWK"},
+			{func(jwk *JsonKey) { jwk.X = "!" }, "error decoding JWK"},
+			{func(jwk *JsonKey) { jwk.X = jwk.X[1:] }, "error decoding JWK"},
+			{func(jwk *JsonKey) { jwk.Y = "" }, "error decoding JWK"},
+			{func(jwk *JsonKey) { jwk.Y = "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5aw" }, "invalid JWK: point is not on curve"},
+			{func(jwk *JsonKey) { jwk.X, jwk.Y = jwk.Y, jwk.X }, "invalid JWK: point is not on curve"},
+		}
+		for _, test := range tests {
+			jwk := valid
+			test.modify(&jwk)
+			response, err := convertJwkToEc(jwk)
+			So(response, ShouldEqual, "")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, test.err)
+		}
+	})
+}
+
+// rfc8037Key is the Ed25519 public key of RFC 8037 appendix A.2
+var rfc8037Key = JsonKey{Kty: "OKP", Crv: "Ed25519", X: "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}
+
+func TestConvertJwkToOkp(t *testing.T) {
+	Convey("Given the Ed25519 JWK of RFC 8037, check it is converted to PKIX DER", t, func() {
+		response, err := convertJwkToOkp(rfc8037Key)
+		So(err, ShouldBeNil)
+		So(response, ShouldEqual, "MCowBQYDK2VwAyEA11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=")
+
+		Convey("Then the converted key verifies the signature of RFC 8037 appendix A.4", func() {
+			der, _ := b64.StdEncoding.DecodeString(response)
+			pk, err := x509.ParsePKIXPublicKey(der)
+			So(err, ShouldBeNil)
+			signature, _ := b64.RawURLEncoding.DecodeString("hgyY0il_MGCjP0JzlnLWG1PPOt7-09PGcvMg3AIbQR6dWbhijcNR4ki4iylGjg5BhVsPt9g7sVvpAr_MuM0KAg")
+			So(ed25519.Verify(pk.(ed25519.PublicKey), []byte("eyJhbGciOiJFZERTQSJ9.RXhhbXBsZSBvZiBFZDI1NTE5IHNpZ25pbmc"), signature), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given invalid OKP JWKs, check they are rejected", t, func() {
+		tests := []struct {
+			modify func(jwk *JsonKey)
+			err    string
+		}{
+			{func(jwk *JsonKey) { jwk.Kty = "EC" }, "unsupported key type. Must be okp key"},
+			{func(jwk *JsonKey) { jwk.Crv = "X25519" }, "unsupported curve. Must be Ed25519"},
+			{func(jwk *JsonKey) { jwk.Crv = "Ed448" }, "unsupported curve. Must be Ed25519"},
+			{func(jwk *JsonKey) { jwk.X = "!" }, "error decoding JWK"},
+			{func(jwk *JsonKey) { jwk.X = "" }, "error decoding JWK"},
+			{func(jwk *JsonKey) { jwk.X = jwk.X[:40] }, "error decoding JWK"},
+		}
+		for _, test := range tests {
+			jwk := rfc8037Key
+			test.modify(&jwk)
+			response, err := convertJwkToOkp(jwk)
+			So(response, ShouldEqual, "")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, test.err)
+		}
+	})
+}
+
+func TestConvertJwk(t *testing.T) {
+	Convey("Given JWKs of each supported key type, check the key type is kept with the converted key", t, func() {
+		rsaKey, err := convertJwk(validJWKS.Keys[0])
+		So(err, ShouldBeNil)
+		So(rsaKey.Kty, ShouldEqual, "RSA")
+		ecKey, err := convertJwk(ecJWK("P-256", elliptic.P256()))
+		So(err, ShouldBeNil)
+		So(ecKey.Kty, ShouldEqual, "EC")
+		okpKey, err := convertJwk(rfc8037Key)
+		So(err, ShouldBeNil)
+		So(okpKey.Kty, ShouldEqual, "OKP")
+	})
+
+	Convey("Given a JWK of an unsupported key type, check it is rejected", t, func() {
+		_, err := convertJwk(JsonKey{Kty: "oct"})
+		So(err.Error(), ShouldEqual, "unsupported key type. Must be RSA, EC or OKP key")
+	})
+}
+
+func TestDecodeExponent(t *testing.T) {
+	Convey("Given the exponent of an RSA JWK, check it is decoded as a big-endian integer or rejected", t, func() {
+		tests := []struct {
+			e        string
+			expected int
+			err      string
+		}{
+			{"AQAB", 65537, ""},
+			{"AAEAAQ", 65537, ""},
+			{"AAAAAQAB", 65537, ""},
+			{"Aw", 3, ""},
+			{"EQ", 17, ""},
+			{"AQE", 257, ""},
+			{"AQAD", 65539, ""},
+			{"f____w", 2147483647, ""},
+			{"", 0, "exponent is empty"},
+			{"AA", 0, "exponent is less than 3"},
+			{"AQ", 0, "exponent is less than 3"},
+			{"Ag", 0, "exponent is less than 3"},
+			{"AQAA", 0, "exponent is even"},
+			{"ABC", 0, "exponent is even"},
+			{"gAAAAQ", 0, "exponent is too large"},
+			{"AQAAAAE", 0, "exponent is too large"},
+			{"AQAB=", 0, "illegal base64 data at input byte 4"},
+			{"AQ+B", 0, "illegal base64 data at input byte 2"},
+		}
+		for _, test := range tests {
+			e, err := decodeExponent(test.e)
+			if test.err == "" {
+				So(err, ShouldBeNil)
+			} else {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, test.err)
+			}
+			So(e, ShouldEqual, test.expected)
+		}
+	})
 }
 
 type MockJWKSRetriever struct{}
 
-func (mjr MockJWKSRetriever) RetrieveJWKS(region, userPoolId string) (io.ReadCloser, int, error) {
+func (mjr MockJWKSRetriever) RetrieveJWKS(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
 	resp := `{"keys": [{"alg":"RS256","e":"AQAB","kid":"j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8=","kty":"RSA","n":"vBvi--N-F9MQO81xh71jIbkx81w4_sGhbztTJgIdhycV-lMzG6y3dMBWo9eRsFJuRs3MUFElmRrTVxc7EPWNQGQjUyPFW0_CnPPoGBCwgCyWtpNs5EHAkCHXsfryHb6LbJxH9LEbwOQCHR25_Bnqo_NeXSBJtvUabq3cTUgdOPc61Hskq-m19M1u7u1xu7b5DHD308Qyz3OhaEHx3cLL2za-mKxHe0VDe3sa5UfdaliTdBypFWJgNl6TsxF_G83fksgb3bVchzW45pu4dEhtNLqgXejH2-GwU8YRaAguKGW7dO_v-5uwLgDYQG9wgtAwLIMiXsFU7muig2pJEtlG2w","use":"sig"}]}`
 	readCloserResp := io.NopCloser(strings.NewReader(resp))
-	return readCloserResp, 200, nil
+	return &JWKSResponse{Body: readCloserResp, StatusCode: 200}, nil
 }
 
 type JWKSRetrieverError struct{}
 
-func (jre JWKSRetrieverError) RetrieveJWKS(region, userPoolId string) (io.ReadCloser, int, error) {
+func (jre JWKSRetrieverError) RetrieveJWKS(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
 	resp := `{"message":"User pool e-wst-4_hoikfhty does not exist."}`
 	readCloserResp := io.NopCloser(strings.NewReader(resp))
-	return readCloserResp, 404, nil
+	return &JWKSResponse{Body: readCloserResp, StatusCode: 404}, nil
 }
 
 type JWKSRetrieverWrongKty struct{}
 
-func (jrwk JWKSRetrieverWrongKty) RetrieveJWKS(region, userPoolId string) (io.ReadCloser, int, error) {
+func (jrwk JWKSRetrieverWrongKty) RetrieveJWKS(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
 	resp := `{"keys": [{"alg":"RS256","e":"AQAB","kid":"j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8=","kty":"ABCD","n":"vBvi--N-F9MQO81xh71jIbkx81w4_sGhbztTJgIdhycV-lMzG6y3dMBWo9eRsFJuRs3MUFElmRrTVxc7EPWNQGQjUyPFW0_CnPPoGBCwgCyWtpNs5EHAkCHXsfryHb6LbJxH9LEbwOQCHR25_Bnqo_NeXSBJtvUabq3cTUgdOPc61Hskq-m19M1u7u1xu7b5DHD308Qyz3OhaEHx3cLL2za-mKxHe0VDe3sa5UfdaliTdBypFWJgNl6TsxF_G83fksgb3bVchzW45pu4dEhtNLqgXejH2-GwU8YRaAguKGW7dO_v-5uwLgDYQG9wgtAwLIMiXsFU7muig2pJEtlG2w","use":"sig"}]}`
 	readCloserResp := io.NopCloser(strings.NewReader(resp))
-	return readCloserResp, 200, nil
+	return &JWKSResponse{Body: readCloserResp, StatusCode: 200}, nil
 }
 
 type JWKSRetrieverHttpErr struct{}
 
-func (jrhe JWKSRetrieverHttpErr) RetrieveJWKS(region, userPoolId string) (io.ReadCloser, int, error) {
-	return nil, 400, errors.New("Http error occured whilst attempting to retrieve JWKS.")
+func (jrhe JWKSRetrieverHttpErr) RetrieveJWKS(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+	return nil, errors.New("Http error occured whilst attempting to retrieve JWKS.")
 }
 
 var ctx = context.Background()
@@ -120,6 +422,8 @@ func TestUserPoolIdHandler(t *testing.T) {
 			userPoolIdHandler.ServeHTTP(resp, req)
 
 			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get("Content-Type"), ShouldEqual, "application/json; charset=utf-8")
+			So(resp.Header().Get("Key-Info"), ShouldEqual, `kid="j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8="; kty="RSA"; thumbprint="5ur0AJvoVqWXlP3eUZWVld3cuUkirE619M49RfhH4LE"`)
 			So(resp.Body.String(), ShouldResemble, expectedResponse)
 		})
 
@@ -128,11 +432,11 @@ func TestUserPoolIdHandler(t *testing.T) {
 			userPoolIdHandler := UserPoolIdHandler(ctx, jre)
 			req := httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil)
 			resp := httptest.NewRecorder()
-			expectedResponse := `"User pool  in region  not found. Try changing the region or user pool ID."`
+			expectedResponse := `{"errors":[{"code":"UserPoolNotFound","description":"User pool  in region  not found. Try changing the region or user pool ID."}]}`
 
 			userPoolIdHandler.ServeHTTP(resp, req)
 
-			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Code, ShouldEqual, http.StatusNotFound)
 			So(resp.Body.String(), ShouldResemble, expectedResponse)
 		})
 
@@ -141,11 +445,11 @@ func TestUserPoolIdHandler(t *testing.T) {
 			userPoolIdHandler := UserPoolIdHandler(ctx, jrwk)
 			req := httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil)
 			resp := httptest.NewRecorder()
-			expectedResponse := `"Failed to retrieve RSA public key"`
+			expectedResponse := `{"errors":[{"code":"KeyConversionFailed","description":"Failed to retrieve public key"}]}`
 
 			userPoolIdHandler.ServeHTTP(resp, req)
 
-			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Code, ShouldEqual, http.StatusInternalServerError)
 			So(resp.Body.String(), ShouldResemble, expectedResponse)
 		})
 
@@ -154,12 +458,68 @@ func TestUserPoolIdHandler(t *testing.T) {
 			userPoolIdHandler := UserPoolIdHandler(ctx, jrhe)
 			req := httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil)
 			resp := httptest.NewRecorder()
-			expectedResponse := `"Http error occured whilst attempting to retrieve JWKS."`
+			expectedResponse := `{"errors":[{"code":"UpstreamError","description":"Http error occured whilst attempting to retrieve JWKS."}]}`
 
 			userPoolIdHandler.ServeHTTP(resp, req)
 
-			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Code, ShouldEqual, http.StatusBadGateway)
 			So(resp.Body.String(), ShouldResemble, expectedResponse)
 		})
+
+		Convey("Given Cognito responds with an unexpected status, check a 502 is returned", func() {
+			userPoolIdHandler := UserPoolIdHandler(ctx, FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+				return &JWKSResponse{Body: io.NopCloser(strings.NewReader("")), StatusCode: http.StatusInternalServerError}, nil
+			}))
+			resp := httptest.NewRecorder()
+
+			userPoolIdHandler.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil))
+
+			So(resp.Code, ShouldEqual, http.StatusBadGateway)
+			So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"UpstreamUnexpectedStatus","description":"unexpected response from AWS Cognito: status 500"}]}`)
+		})
+
+		Convey("Given the request to Cognito times out, check a 504 is returned", func() {
+			userPoolIdHandler := UserPoolIdHandler(ctx, FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+				return nil, newUpstreamError(fmt.Errorf("request abandoned: %w", context.DeadlineExceeded))
+			}))
+			resp := httptest.NewRecorder()
+
+			userPoolIdHandler.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil))
+
+			So(resp.Code, ShouldEqual, http.StatusGatewayTimeout)
+			So(resp.Header().Get("Content-Type"), ShouldEqual, "application/json; charset=utf-8")
+			So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"UpstreamTimeout","description":"request to AWS Cognito timed out: request abandoned: context deadline exceeded"}]}`)
+		})
+
+		Convey("Given Cognito responds with a body that is not JSON, check a 502 saying so is returned", func() {
+			userPoolIdHandler := UserPoolIdHandler(ctx, FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+				return &JWKSResponse{Body: io.NopCloser(strings.NewReader("<html>Bad Gateway</html>")), StatusCode: http.StatusOK}, nil
+			}))
+			resp := httptest.NewRecorder()
+
+			userPoolIdHandler.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil))
+
+			So(resp.Code, ShouldEqual, http.StatusBadGateway)
+			So(resp.Body.String(), ShouldStartWith, `{"errors":[{"code":"UpstreamInvalidJSON","description":"response from AWS Cognito is not JSON: `)
+		})
+
+		Convey("Given Cognito responds with a body that is too large, check a 502 saying so is returned", func() {
+			userPoolIdHandler := UserPoolIdHandler(ctx, FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+				return &JWKSResponse{Body: io.NopCloser(strings.NewReader(strings.Repeat(" ", DefaultMaxJWKSBodySize+1))), StatusCode: http.StatusOK}, nil
+			}))
+			resp := httptest.NewRecorder()
+
+			userPoolIdHandler.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/region/userPoolId", nil))
+
+			So(resp.Code, ShouldEqual, http.StatusBadGateway)
+			So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"UpstreamBodyTooLarge","description":"response from AWS Cognito is too large: body exceeds 1048576 bytes"}]}`)
+		})
 	})
 }
+
+// FuncJWKSRetriever adapts a function into a JWKSRetriever
+type FuncJWKSRetriever func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error)
+
+func (f FuncJWKSRetriever) RetrieveJWKS(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+	return f(ctx, region, userPoolId, prev)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"net/http"
+)
+
+// KeyList is the v2 response: every key of a user pool with what a consumer needs to pick its verifier
+type KeyList struct {
+	Keys []KeyDescription `json:"keys"`
+	// KeyErrors are the keys left out of Keys and why
+	KeyErrors []KeyError `json:"key_errors,omitempty"`
+}
+
+// KeyDescription is a key in a KeyList
+type KeyDescription struct {
+	Kid string `json:"kid"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	// Bits is the size of the key: the modulus of an RSA key, the curve of an EC or OKP key
+	Bits int `json:"bits"`
+	// Thumbprint is the RFC 7638 SHA-256 thumbprint of the JWK
+	Thumbprint string `json:"thumbprint"`
+	// Key is the base64 encoded PKIX DER of the key
+	Key string `json:"key"`
+	// Certificate is the x5c certificate chain of the key as PEM, leaf first, if the JWK has one
+	Certificate string `json:"certificate,omitempty"`
+}
+
+// UserPoolIdV2Handler serves the keys of a user pool as a KeyList
+func UserPoolIdV2Handler(ctx context.Context, jr JWKSRetriever) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars, ok := pathVars(w, req, "region", "userPoolId")
+		if !ok {
+			return
+		}
+		region, userPoolId := vars[0], vars[1]
+		keySet, err := retrieveKeys(req.Context(), jr, region, userPoolId)
+		if err != nil {
+			writeRetrievalError(w, err, region, userPoolId)
+			return
+		}
+		setFreshnessHeaders(w.Header(), keySet)
+		writeJSONResponse(w, http.StatusOK, newKeyList(keySet))
+	}
+}
+
+// newKeyList describes the keys in order of kid
+func newKeyList(keySet KeySet) KeyList {
+	list := KeyList{Keys: []KeyDescription{}, KeyErrors: keySet.KeyErrors}
+	for _, key := range sortedKeys(keySet.Keys) {
+		list.Keys = append(list.Keys, KeyDescription{
+			Kid:         key.JWK.Kid,
+			Alg:         key.JWK.Alg,
+			Use:         key.JWK.Use,
+			Kty:         key.Kty,
+			Crv:         key.JWK.Crv,
+			Bits:        key.Bits,
+			Thumbprint:  key.Thumbprint,
+			Key:         key.DER,
+			Certificate: string(encodeCertificates(key.Certificates)),
+		})
+	}
+	return list
+}
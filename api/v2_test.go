@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewKeyList(t *testing.T) {
+	Convey("Given keys of every supported type, check each is described in order of kid", t, func() {
+		list := newKeyList(KeySet{Keys: testKeys(t)})
+
+		So(list.Keys, ShouldResemble, []KeyDescription{
+			{
+				Kid:        "ec-key",
+				Alg:        "ES256",
+				Use:        "sig",
+				Kty:        "EC",
+				Crv:        "P-256",
+				Bits:       256,
+				Thumbprint: "oKIywvGUpTVTyxMQ3bwIIeQUudfr_CkLMjCE19ECD-U",
+				Key:        "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEf83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEXH8UTNG72bfocs3+257rn0s2ldbqkLJK2KRiMohYjlrQ==",
+			},
+			{
+				Kid:        "j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8=",
+				Alg:        "RS256",
+				Use:        "sig",
+				Kty:        "RSA",
+				Bits:       2048,
+				Thumbprint: "5ur0AJvoVqWXlP3eUZWVld3cuUkirE619M49RfhH4LE",
+				Key:        "MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAvBvi++N+F9MQO81xh71jIbkx81w4/sGhbztTJgIdhycV+lMzG6y3dMBWo9eRsFJuRs3MUFElmRrTVxc7EPWNQGQjUyPFW0/CnPPoGBCwgCyWtpNs5EHAkCHXsfryHb6LbJxH9LEbwOQCHR25/Bnqo/NeXSBJtvUabq3cTUgdOPc61Hskq+m19M1u7u1xu7b5DHD308Qyz3OhaEHx3cLL2za+mKxHe0VDe3sa5UfdaliTdBypFWJgNl6TsxF/G83fksgb3bVchzW45pu4dEhtNLqgXejH2+GwU8YRaAguKGW7dO/v+5uwLgDYQG9wgtAwLIMiXsFU7muig2pJEtlG2wIDAQAB",
+			},
+			{
+				Kid:        "okp-key",
+				Alg:        "EdDSA",
+				Use:        "sig",
+				Kty:        "OKP",
+				Crv:        "Ed25519",
+				Bits:       256,
+				Thumbprint: "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k",
+				Key:        "MCowBQYDK2VwAyEA11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=",
+			},
+		})
+	})
+}
+
+func TestUserPoolIdV2Handler(t *testing.T) {
+	Convey("Given a v2 user pool id handler", t, func() {
+		r := mux.NewRouter()
+		r.HandleFunc("/v2/{region}/{userPoolId}", UserPoolIdV2Handler(ctx, new(MockJWKSRetriever)))
+
+		Convey("When the keys of a user pool are requested, check they are listed with their metadata", func() {
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/v2/eu-west-2/pool", nil))
+
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get("Content-Type"), ShouldEqual, "application/json; charset=utf-8")
+			So(resp.Header().Get("Age"), ShouldEqual, "0")
+			So(resp.Body.String(), ShouldStartWith, `{"keys":[{"kid":"j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8=","alg":"RS256","use":"sig","kty":"RSA","bits":2048,"thumbprint":"`)
+		})
+	})
+
+	Convey("Given a v2 user pool id handler for an unknown user pool, check the 404 error response is returned", t, func() {
+		resp := httptest.NewRecorder()
+		UserPoolIdV2Handler(ctx, new(JWKSRetrieverError)).ServeHTTP(resp, httptest.NewRequest("GET", "http://localhost:25999/v2/region/userPoolId", nil))
+
+		So(resp.Code, ShouldEqual, http.StatusNotFound)
+		So(resp.Body.String(), ShouldContainSubstring, `"code":"UserPoolNotFound"`)
+	})
+}
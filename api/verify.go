@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// VerifyConfig controls how VerifyHandler verifies tokens
+type VerifyConfig struct {
+	// IssuerTemplate is the iss of a user pool's tokens with {region} and {userPoolId} placeholders,
+	// DefaultIssuerTemplate if empty
+	IssuerTemplate string
+	// Leeway is the clock skew allowed when checking exp, nbf and iat
+	Leeway time.Duration
+	// Profiles are the Cognito specific rules the tokens of user pools that have one must meet
+	Profiles CognitoProfiles
+}
+
+// maxVerifyRequestSize is the largest verify request read, far larger than any token Cognito issues
+const maxVerifyRequestSize = 64 << 10
+
+// VerifyRequest is the body of a verify request: a token, the user pool that should have issued it and, optionally,
+// the token_use it should have
+type VerifyRequest struct {
+	Token      string `json:"token"`
+	Region     string `json:"region"`
+	UserPoolId string `json:"user_pool_id"`
+	TokenUse   string `json:"token_use,omitempty"`
+}
+
+// Verdict is the response to a verify request: whether the token is valid and its claims, or why it is not
+type Verdict struct {
+	Valid  bool                   `json:"valid"`
+	Kid    string                 `json:"kid,omitempty"`
+	Alg    string                 `json:"alg,omitempty"`
+	Claims map[string]interface{} `json:"claims,omitempty"`
+	Error  *Error                 `json:"error,omitempty"`
+}
+
+// VerifyHandler verifies a token with the keys of the user pool that should have issued it, responding with a
+// Verdict. A token that fails verification is not an error: the Verdict says why it failed.
+func VerifyHandler(ctx context.Context, jr JWKSRetriever, cfg VerifyConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var verifyReq VerifyRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxVerifyRequestSize)).Decode(&verifyReq); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest, "Request body is not a verify request: "+err.Error())
+			return
+		}
+		if verifyReq.Token == "" || verifyReq.Region == "" || verifyReq.UserPoolId == "" {
+			writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest, "token, region and user_pool_id are required")
+			return
+		}
+		if verifyReq.TokenUse != "" && verifyReq.TokenUse != TokenUseID && verifyReq.TokenUse != TokenUseAccess {
+			writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("token_use must be %q or %q", TokenUseID, TokenUseAccess))
+			return
+		}
+		token, err := VerifyToken(req.Context(), jr, cfg, verifyReq.Token, verifyReq.Region, verifyReq.UserPoolId, verifyReq.TokenUse)
+		var tokenErr *TokenError
+		if err != nil && !errors.As(err, &tokenErr) {
+			writeRetrievalError(w, err, verifyReq.Region, verifyReq.UserPoolId)
+			return
+		}
+		writeVerdict(w, token, err)
+	}
+}
+
+// VerifyToken verifies a raw token with the keys of the user pool that should have issued it: its signature, its
+// registered claims and, if the user pool has a profile or a token use is given, its Cognito claims. A token that
+// fails verification returns a TokenError, along with the token if it could be parsed; any other error is a failure
+// to retrieve the keys.
+func VerifyToken(ctx context.Context, jr JWKSRetriever, cfg VerifyConfig, raw, region, userPoolId, tokenUse string) (*Token, error) {
+	token, err := ParseToken(raw)
+	if err != nil {
+		return nil, err
+	}
+	keySet, err := retrieveKey(ctx, jr, region, userPoolId, token.Header.Kid)
+	if err != nil {
+		return token, err
+	}
+	key, ok := keySet.Keys[token.Header.Kid]
+	if !ok {
+		return token, tokenError(CodeKeyNotFound, "key %s not found in user pool %s in region %s", token.Header.Kid, userPoolId, region)
+	}
+	if err := token.VerifySignature(key); err != nil {
+		return token, err
+	}
+	issuer := issuerURL(cfg.IssuerTemplate, region, userPoolId)
+	if err := token.ValidateClaims(issuer, time.Now(), cfg.Leeway); err != nil {
+		return token, err
+	}
+	return token, cfg.Profiles.Validate(region, userPoolId, token, tokenUse)
+}
+
+// writeVerdict writes the Verdict on a token, valid unless verifying it failed. Errors other than TokenErrors are
+// failures of the service rather than the token.
+func writeVerdict(w http.ResponseWriter, token *Token, err error) {
+	var tokenErr *TokenError
+	if err != nil && !errors.As(err, &tokenErr) {
+		writeErrorResponse(w, http.StatusInternalServerError, CodeInternalServerError, fmt.Sprintf("Failed to verify token: %s", err.Error()))
+		return
+	}
+	verdict := Verdict{Valid: err == nil}
+	if token != nil {
+		verdict.Kid, verdict.Alg = token.Header.Kid, token.Header.Alg
+	}
+	if err != nil {
+		verdict.Error = &Error{Code: tokenErr.Code, Description: tokenErr.Reason}
+	} else {
+		verdict.Claims = token.Claims
+	}
+	writeJSONResponse(w, http.StatusOK, verdict)
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testJWKSRetriever returns a JWKSRetriever serving the keys of the signers
+func testJWKSRetriever(t *testing.T, signers ...testSigner) JWKSRetriever {
+	keys := make([]string, len(signers))
+	for i, signer := range signers {
+		keys[i] = jsonKey(t, signer.key.JWK)
+	}
+	jwks := `{"keys":[` + strings.Join(keys, ",") + `]}`
+	return FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+		return &JWKSResponse{Body: io.NopCloser(strings.NewReader(jwks)), StatusCode: http.StatusOK}, nil
+	})
+}
+
+func TestVerifyHandler(t *testing.T) {
+	Convey("Given a user pool with a signing key", t, func() {
+		signer := newTestSigner(t, "RS256", "abc")
+		handler := VerifyHandler(ctx, testJWKSRetriever(t, signer), VerifyConfig{})
+		now := time.Now()
+		claims := map[string]interface{}{
+			"iss": "https://cognito-idp.eu-west-2.amazonaws.com/eu-west-2_abc",
+			"sub": "user",
+			"iat": now.Add(-time.Minute).Unix(),
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		verify := func(body string) (*httptest.ResponseRecorder, Verdict) {
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, httptest.NewRequest("POST", "http://localhost:25999/verify", strings.NewReader(body)))
+			var verdict Verdict
+			json.Unmarshal(resp.Body.Bytes(), &verdict)
+			return resp, verdict
+		}
+		request := func(token string) string {
+			body, _ := json.Marshal(VerifyRequest{Token: token, Region: "eu-west-2", UserPoolId: "eu-west-2_abc"})
+			return string(body)
+		}
+
+		Convey("When a token it signed is verified, check it is valid with its claims", func() {
+			resp, verdict := verify(request(signer.sign(t, claims)))
+
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get("Content-Type"), ShouldEqual, "application/json; charset=utf-8")
+			So(verdict.Valid, ShouldBeTrue)
+			So(verdict.Kid, ShouldEqual, "abc")
+			So(verdict.Alg, ShouldEqual, "RS256")
+			So(verdict.Claims["sub"], ShouldEqual, "user")
+			So(verdict.Error, ShouldBeNil)
+		})
+
+		Convey("When a token it signed that has expired is verified, check it is invalid saying so", func() {
+			claims["exp"] = now.Add(-time.Minute).Unix()
+			resp, verdict := verify(request(signer.sign(t, claims)))
+
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(verdict.Valid, ShouldBeFalse)
+			So(verdict.Claims, ShouldBeNil)
+			So(verdict.Error.Code, ShouldEqual, CodeTokenExpired)
+		})
+
+		Convey("When a token issued by another user pool is verified, check it is invalid saying so", func() {
+			claims["iss"] = "https://cognito-idp.eu-west-2.amazonaws.com/eu-west-2_xyz"
+			_, verdict := verify(request(signer.sign(t, claims)))
+
+			So(verdict.Valid, ShouldBeFalse)
+			So(verdict.Error, ShouldResemble, &Error{Code: CodeInvalidIssuer, Description: "iss https://cognito-idp.eu-west-2.amazonaws.com/eu-west-2_xyz is not https://cognito-idp.eu-west-2.amazonaws.com/eu-west-2_abc"})
+		})
+
+		Convey("When a token signed by another key with its kid is verified, check it is invalid saying so", func() {
+			_, verdict := verify(request(newTestSigner(t, "RS256", "abc").sign(t, claims)))
+
+			So(verdict.Valid, ShouldBeFalse)
+			So(verdict.Error.Code, ShouldEqual, CodeInvalidSignature)
+		})
+
+		Convey("When a token signed by a key it does not have is verified, check it is invalid saying so", func() {
+			_, verdict := verify(request(newTestSigner(t, "RS256", "xyz").sign(t, claims)))
+
+			So(verdict.Valid, ShouldBeFalse)
+			So(verdict.Kid, ShouldEqual, "xyz")
+			So(verdict.Error, ShouldResemble, &Error{Code: CodeKeyNotFound, Description: "key xyz not found in user pool eu-west-2_abc in region eu-west-2"})
+		})
+
+		Convey("When a malformed token is verified, check it is invalid saying so", func() {
+			resp, verdict := verify(request("abc"))
+
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldEqual, `{"valid":false,"error":{"code":"TokenMalformed","description":"token must have 3 parts, has 1"}}`)
+			So(verdict.Valid, ShouldBeFalse)
+		})
+
+		Convey("When the request is not JSON, check a 400 is returned", func() {
+			resp, _ := verify("token")
+
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Body.String(), ShouldStartWith, `{"errors":[{"code":"InvalidRequest","description":"Request body is not a verify request: `)
+		})
+
+		Convey("When the request has no user pool, check a 400 is returned", func() {
+			resp, _ := verify(`{"token":"abc","region":"eu-west-2"}`)
+
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"InvalidRequest","description":"token, region and user_pool_id are required"}]}`)
+		})
+	})
+
+	Convey("Given a user pool that does not exist, check verifying a token for it returns a 404", t, func() {
+		handler := VerifyHandler(ctx, FuncJWKSRetriever(func(ctx context.Context, region, userPoolId string, prev ResponseMetadata) (*JWKSResponse, error) {
+			return &JWKSResponse{Body: io.NopCloser(strings.NewReader("")), StatusCode: http.StatusNotFound}, nil
+		}), VerifyConfig{})
+		signer := newTestSigner(t, "RS256", "abc")
+		body, _ := json.Marshal(VerifyRequest{Token: signer.sign(t, nil), Region: "eu-west-2", UserPoolId: "eu-west-2_xyz"})
+		resp := httptest.NewRecorder()
+
+		handler.ServeHTTP(resp, httptest.NewRequest("POST", "http://localhost:25999/verify", strings.NewReader(string(body))))
+
+		So(resp.Code, ShouldEqual, http.StatusNotFound)
+		So(resp.Body.String(), ShouldContainSubstring, CodeUserPoolNotFound)
+	})
+
+	Convey("Given a verifier with a Cognito profile for the user pool", t, func() {
+		signer := newTestSigner(t, "RS256", "abc")
+		profiles, err := NewCognitoProfiles([]CognitoProfile{{Region: "eu-west-2", UserPoolId: "eu-west-2_abc", IDToken: &IDTokenRules{ClientIds: []string{"florence"}}}})
+		So(err, ShouldBeNil)
+		handler := VerifyHandler(ctx, testJWKSRetriever(t, signer), VerifyConfig{Profiles: profiles})
+		claims := map[string]interface{}{
+			"iss":       "https://cognito-idp.eu-west-2.amazonaws.com/eu-west-2_abc",
+			"iat":       time.Now().Add(-time.Minute).Unix(),
+			"exp":       time.Now().Add(time.Hour).Unix(),
+			"token_use": "id",
+			"aud":       "florence",
+		}
+		verify := func(tokenUse string) Verdict {
+			body, _ := json.Marshal(VerifyRequest{Token: signer.sign(t, claims), Region: "eu-west-2", UserPoolId: "eu-west-2_abc", TokenUse: tokenUse})
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, httptest.NewRequest("POST", "http://localhost:25999/verify", strings.NewReader(string(body))))
+			var verdict Verdict
+			json.Unmarshal(resp.Body.Bytes(), &verdict)
+			return verdict
+		}
+
+		Convey("When an ID token for an allowed app client is verified, check it is valid", func() {
+			So(verify("id").Valid, ShouldBeTrue)
+		})
+
+		Convey("When an ID token for another app client is verified, check it is invalid saying so", func() {
+			claims["aud"] = "zebedee"
+			So(verify("").Error, ShouldResemble, &Error{Code: CodeInvalidAudience, Description: `aud "zebedee" is not an allowed app client`})
+		})
+
+		Convey("When an ID token is verified as an access token, check it is invalid saying so", func() {
+			So(verify("access").Error, ShouldResemble, &Error{Code: CodeInvalidTokenUse, Description: `token_use "id" is not "access"`})
+		})
+
+		Convey("When an access token is verified, check it is invalid as the user pool accepts none", func() {
+			claims["token_use"] = "access"
+			So(verify("").Error, ShouldResemble, &Error{Code: CodeInvalidTokenUse, Description: `token_use "access" is not accepted by user pool eu-west-2_abc`})
+		})
+	})
+
+	Convey("Given a verify request with an unknown token_use, check a 400 is returned", t, func() {
+		handler := VerifyHandler(ctx, MockJWKSRetriever{}, VerifyConfig{})
+		resp := httptest.NewRecorder()
+
+		handler.ServeHTTP(resp, httptest.NewRequest("POST", "http://localhost:25999/verify", strings.NewReader(`{"token":"abc","region":"eu-west-2","user_pool_id":"eu-west-2_abc","token_use":"refresh"}`)))
+
+		So(resp.Code, ShouldEqual, http.StatusBadRequest)
+		So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"InvalidRequest","description":"token_use must be \"id\" or \"access\""}]}`)
+	})
+
+	Convey("Given a verifier with an issuer template and leeway, check a token expired within the leeway from that issuer is valid", t, func() {
+		signer := newTestSigner(t, "ES256", "abc")
+		handler := VerifyHandler(ctx, testJWKSRetriever(t, signer), VerifyConfig{IssuerTemplate: "https://idp.example.com/{region}/{userPoolId}", Leeway: time.Minute})
+		token := signer.sign(t, map[string]interface{}{
+			"iss": "https://idp.example.com/eu-west-2/eu-west-2_abc",
+			"iat": time.Now().Add(-time.Hour).Unix(),
+			"exp": time.Now().Add(-10 * time.Second).Unix(),
+		})
+		body, _ := json.Marshal(VerifyRequest{Token: token, Region: "eu-west-2", UserPoolId: "eu-west-2_abc"})
+		resp := httptest.NewRecorder()
+
+		handler.ServeHTTP(resp, httptest.NewRequest("POST", "http://localhost:25999/verify", strings.NewReader(string(body))))
+
+		So(resp.Body.String(), ShouldStartWith, `{"valid":true`)
+	})
+}
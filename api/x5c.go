@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	b64 "encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// parseCertificateChain parses the x5c certificate chain of a JWK, leaf first, checking that the leaf certificate
+// holds the key converted from the JWK and matches any x5t or x5t#S256 thumbprint. A JWK without x5c has no chain.
+func parseCertificateChain(jwk JsonKey, der string) ([]*x509.Certificate, error) {
+	if len(jwk.X5c) == 0 {
+		return nil, nil
+	}
+	chain := make([]*x509.Certificate, len(jwk.X5c))
+	for i, encoded := range jwk.X5c {
+		certDER, err := b64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding x5c certificate %d", i)
+		}
+		if chain[i], err = x509.ParseCertificate(certDER); err != nil {
+			return nil, fmt.Errorf("error parsing x5c certificate %d: %s", i, err.Error())
+		}
+	}
+	leaf := chain[0]
+	leafKey, err := x509.MarshalPKIXPublicKey(leaf.PublicKey)
+	if err != nil || b64.StdEncoding.EncodeToString(leafKey) != der {
+		return nil, errors.New("x5c leaf certificate does not hold the key of the JWK")
+	}
+	if jwk.X5t != "" {
+		sum := sha1.Sum(leaf.Raw)
+		if jwk.X5t != b64.RawURLEncoding.EncodeToString(sum[:]) {
+			return nil, errors.New("x5t does not match the x5c leaf certificate")
+		}
+	}
+	if jwk.X5tS256 != "" {
+		sum := sha256.Sum256(leaf.Raw)
+		if jwk.X5tS256 != b64.RawURLEncoding.EncodeToString(sum[:]) {
+			return nil, errors.New("x5t#S256 does not match the x5c leaf certificate")
+		}
+	}
+	return chain, nil
+}
+
+// verifyCertificateChain checks that a certificate chain, leaf first, leads to one of the roots
+func verifyCertificateChain(chain []*x509.Certificate, roots *x509.CertPool) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("x5c certificate chain does not verify: %s", err.Error())
+	}
+	return nil
+}
+
+// encodeCertificates returns a certificate chain as PEM CERTIFICATE blocks, leaf first
+func encodeCertificates(chain []*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, cert := range chain {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.Bytes()
+}
+
+// LoadCABundle reads a PEM file of CA certificates that x5c certificate chains are verified against
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	bundle, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+	return roots, nil
+}
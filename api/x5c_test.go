@@ -0,0 +1,222 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	b64 "encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testCA is a throwaway certificate authority that issues certificates for x5c tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) testCA {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCA{cert: cert, key: key}
+}
+
+func (ca testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issueJWK returns an RSA JWK whose x5c is a certificate for it issued by the CA
+func (ca testCA) issueJWK(t *testing.T, kid string) (JsonKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: kid},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return JsonKey{
+		Kty: "RSA",
+		Kid: kid,
+		Alg: "RS256",
+		Use: "sig",
+		N:   b64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   b64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		X5c: []string{b64.StdEncoding.EncodeToString(der)},
+	}, cert
+}
+
+func TestParseCertificateChain(t *testing.T) {
+	ca := newTestCA(t)
+
+	Convey("Given a JWK with an x5c certificate chain", t, func() {
+		jwk, cert := ca.issueJWK(t, "abc")
+
+		Convey("When it is converted, check the chain is parsed", func() {
+			key, err := convertJwk(jwk)
+			So(err, ShouldBeNil)
+			So(key.Certificates, ShouldHaveLength, 1)
+			So(key.Certificates[0].Equal(cert), ShouldBeTrue)
+		})
+
+		Convey("When its x5t matches the leaf certificate, check it is converted", func() {
+			sum := sha1.Sum(cert.Raw)
+			jwk.X5t = b64.RawURLEncoding.EncodeToString(sum[:])
+			_, err := convertJwk(jwk)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("When its x5t does not match the leaf certificate, check it is not converted", func() {
+			jwk.X5t = b64.RawURLEncoding.EncodeToString(make([]byte, sha1.Size))
+			_, err := convertJwk(jwk)
+			So(err.Error(), ShouldEqual, "x5t does not match the x5c leaf certificate")
+		})
+
+		Convey("When its x5t#S256 does not match the leaf certificate, check it is not converted", func() {
+			jwk.X5tS256 = "AAAA"
+			_, err := convertJwk(jwk)
+			So(err.Error(), ShouldEqual, "x5t#S256 does not match the x5c leaf certificate")
+		})
+
+		Convey("When its leaf certificate holds a different key, check it is not converted", func() {
+			other, _ := ca.issueJWK(t, "other")
+			jwk.X5c = other.X5c
+			_, err := convertJwk(jwk)
+			So(err.Error(), ShouldEqual, "x5c leaf certificate does not hold the key of the JWK")
+		})
+
+		Convey("When its x5c is not a certificate, check it is not converted", func() {
+			jwk.X5c = []string{"AAAA"}
+			_, err := convertJwk(jwk)
+			So(err.Error(), ShouldStartWith, "error parsing x5c certificate 0")
+		})
+
+		Convey("When its x5c is not base64, check it is not converted", func() {
+			jwk.X5c = []string{"!"}
+			_, err := convertJwk(jwk)
+			So(err.Error(), ShouldEqual, "error decoding x5c certificate 0")
+		})
+	})
+}
+
+func TestVerifyCertificateChain(t *testing.T) {
+	ca := newTestCA(t)
+
+	Convey("Given a JWKS with a key whose certificate is issued by the CA and one whose certificate is not", t, func() {
+		issued, _ := ca.issueJWK(t, "issued")
+		unknown, _ := newTestCA(t).issueJWK(t, "unknown")
+		jwks := JWKS{Keys: []JsonKey{issued, unknown, validJWKS.Keys[0]}}
+
+		Convey("When it is converted with the CA as a root, check the key that does not lead to it is left out", func() {
+			keySet, err := convertJwks(jwks, ConversionConfig{CARoots: ca.pool()})
+			So(err, ShouldBeNil)
+			So(keySet.Keys, ShouldContainKey, "issued")
+			So(keySet.Keys, ShouldContainKey, validJWKS.Keys[0].Kid)
+			So(keySet.Keys, ShouldNotContainKey, "unknown")
+			So(keySet.KeyErrors, ShouldHaveLength, 1)
+			So(keySet.KeyErrors[0].Kid, ShouldEqual, "unknown")
+			So(keySet.KeyErrors[0].Reason, ShouldStartWith, "x5c certificate chain does not verify")
+		})
+
+		Convey("When it is converted without roots, check every key is kept", func() {
+			keySet, err := convertJwks(jwks, ConversionConfig{})
+			So(err, ShouldBeNil)
+			So(keySet.Keys, ShouldHaveLength, 3)
+		})
+	})
+}
+
+func TestCertificateOutput(t *testing.T) {
+	ca := newTestCA(t)
+
+	Convey("Given a key with an x5c certificate chain", t, func() {
+		jwk, cert := ca.issueJWK(t, "abc")
+		key, err := convertJwk(jwk)
+		So(err, ShouldBeNil)
+		certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+
+		Convey("When it is rendered as PEM, check the certificate follows the public key", func() {
+			body, err := renderPEM([]PublicKey{key})
+			So(err, ShouldBeNil)
+			So(string(body), ShouldStartWith, "kid: abc\n-----BEGIN PUBLIC KEY-----\n")
+			So(string(body), ShouldEndWith, "-----END PUBLIC KEY-----\n"+certPEM)
+		})
+
+		Convey("When it is described in v2, check the certificate is given as PEM", func() {
+			list := newKeyList(KeySet{Keys: map[string]PublicKey{"abc": key}})
+			So(list.Keys[0].Certificate, ShouldEqual, certPEM)
+		})
+	})
+}
+
+func TestLoadCABundle(t *testing.T) {
+	ca := newTestCA(t)
+	dir, err := ioutil.TempDir("", "ca-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	Convey("Given a PEM file holding a CA certificate, check it is loaded", t, func() {
+		path := filepath.Join(dir, "bundle.pem")
+		So(ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0600), ShouldBeNil)
+		roots, err := LoadCABundle(path)
+		So(err, ShouldBeNil)
+		So(roots, ShouldNotBeNil)
+	})
+
+	Convey("Given a file holding no certificates, check an error is returned", t, func() {
+		path := filepath.Join(dir, "empty.pem")
+		So(ioutil.WriteFile(path, []byte("not a certificate"), 0600), ShouldBeNil)
+		_, err := LoadCABundle(path)
+		So(err.Error(), ShouldEqual, "no certificates found in CA bundle "+path)
+	})
+
+	Convey("Given a file that does not exist, check an error is returned", t, func() {
+		_, err := LoadCABundle(filepath.Join(dir, "missing.pem"))
+		So(err, ShouldNotBeNil)
+		So(strings.Contains(err.Error(), "missing.pem"), ShouldBeTrue)
+	})
+}
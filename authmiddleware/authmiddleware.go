@@ -0,0 +1,135 @@
+// Package authmiddleware authenticates requests to a dp service with the JWTs of an AWS Cognito user pool. Tokens are
+// verified with the user pool's keys as served by dp-retrieve-public-signing-keys-aws-cognito, which are converted
+// and cached locally by the same code the service uses.
+package authmiddleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-retrieve-public-signing-keys-aws-cognito/api"
+)
+
+// CodeMissingToken is the error code of a request without a bearer token
+const CodeMissingToken = "MissingToken"
+
+// DefaultCacheConfig is how keys are cached where Config leaves the TTL, MaxStale or KidRefreshInterval zero
+var DefaultCacheConfig = api.CacheConfig{
+	TTL:                5 * time.Minute,
+	MaxStale:           time.Hour,
+	KidRefreshInterval: 30 * time.Second,
+}
+
+// Config says which user pool's tokens a Middleware accepts and where it gets their keys
+type Config struct {
+	// KeysURL is the URL of dp-retrieve-public-signing-keys-aws-cognito, e.g. http://localhost:25999
+	KeysURL    string
+	Region     string
+	UserPoolId string
+	// TokenUse, when not empty, is the token_use every token must have
+	TokenUse string
+	// Client makes the requests for keys, http.DefaultClient if nil
+	Client *http.Client
+	// Cache controls the local cache of keys, taking the TTL, MaxStale and KidRefreshInterval of DefaultCacheConfig
+	// where they are zero. A negative TTL disables caching, a negative MaxStale serving stale keys and a negative
+	// KidRefreshInterval asking the service for kids that are not cached.
+	Cache api.CacheConfig
+	// Verify controls how tokens are verified
+	Verify api.VerifyConfig
+}
+
+// Middleware verifies the bearer token of every request before passing it on with the token's claims
+type Middleware struct {
+	cfg       Config
+	retriever *serviceKeys
+}
+
+// New returns a Middleware that verifies tokens with keys retrieved from the service at cfg.KeysURL
+func New(cfg Config) *Middleware {
+	if cfg.Cache.TTL == 0 {
+		cfg.Cache.TTL = DefaultCacheConfig.TTL
+	}
+	switch {
+	case cfg.Cache.MaxStale == 0:
+		cfg.Cache.MaxStale = DefaultCacheConfig.MaxStale
+	case cfg.Cache.MaxStale < 0:
+		cfg.Cache.MaxStale = 0
+	}
+	if cfg.Cache.KidRefreshInterval == 0 {
+		cfg.Cache.KidRefreshInterval = DefaultCacheConfig.KidRefreshInterval
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	return &Middleware{
+		cfg:       cfg,
+		retriever: newServiceKeys(cfg.Client, strings.TrimSuffix(cfg.KeysURL, "/"), cfg.Cache),
+	}
+}
+
+// Handler wraps a handler so that it is only called for requests with a valid bearer token, whose claims are on
+// the request context. Other requests are refused with a 401, or a 500 if the keys cannot be retrieved.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		raw, ok := bearerToken(req)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, CodeMissingToken, "Request has no bearer token")
+			return
+		}
+		token, err := m.Verify(req.Context(), raw)
+		var tokenErr *api.TokenError
+		switch {
+		case errors.As(err, &tokenErr):
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, tokenErr.Reason))
+			writeError(w, http.StatusUnauthorized, tokenErr.Code, tokenErr.Reason)
+		case err != nil:
+			log.Printf("Failed to retrieve keys to verify token for user pool %s in region %s.\nError:%s\n", m.cfg.UserPoolId, m.cfg.Region, err.Error())
+			writeError(w, http.StatusInternalServerError, api.CodeInternalServerError, "Failed to verify token")
+		default:
+			next.ServeHTTP(w, req.WithContext(NewContext(req.Context(), token.Claims)))
+		}
+	})
+}
+
+// Verify verifies a raw token, returning an api.TokenError if it is not valid
+func (m *Middleware) Verify(ctx context.Context, raw string) (*api.Token, error) {
+	return api.VerifyToken(ctx, m.retriever, m.cfg.Verify, raw, m.cfg.Region, m.cfg.UserPoolId, m.cfg.TokenUse)
+}
+
+// bearerToken returns the token of a request's Authorization header, if it is a bearer token
+func bearerToken(req *http.Request) (string, bool) {
+	parts := strings.SplitN(req.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", false
+	}
+	token := strings.TrimSpace(parts[1])
+	return token, token != ""
+}
+
+type claimsKey struct{}
+
+// NewContext returns a context holding a token's claims
+func NewContext(ctx context.Context, claims map[string]interface{}) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims of the token a request was authenticated with, if it was
+func ClaimsFromContext(ctx context.Context) (map[string]interface{}, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(map[string]interface{})
+	return claims, ok
+}
+
+// writeError writes an error response in the form the service writes its own
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	body, _ := json.Marshal(api.ErrorResponse{Errors: []api.Error{{Code: code, Description: description}}})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
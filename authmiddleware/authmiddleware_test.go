@@ -0,0 +1,202 @@
+package authmiddleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-retrieve-public-signing-keys-aws-cognito/api"
+	"github.com/ONSdigital/dp-retrieve-public-signing-keys-aws-cognito/authmiddleware/authtest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	region     = "eu-west-2"
+	userPoolId = "eu-west-2_abc"
+)
+
+// claimsHandler responds with the sub claim on the request context
+var claimsHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	claims, ok := ClaimsFromContext(req.Context())
+	if !ok {
+		w.WriteHeader(http.StatusTeapot)
+		return
+	}
+	w.Write([]byte(claims["sub"].(string)))
+})
+
+func TestMiddleware(t *testing.T) {
+	Convey("Given a middleware in front of the keys of a user pool", t, func() {
+		signer, err := authtest.NewSigner("abc")
+		So(err, ShouldBeNil)
+		var keyRequests int32
+		server := authtest.NewServer(signer)
+		counting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&keyRequests, 1)
+			server.Config.Handler.ServeHTTP(w, req)
+		}))
+		defer server.Close()
+		defer counting.Close()
+		handler := New(Config{KeysURL: counting.URL, Region: region, UserPoolId: userPoolId}).Handler(claimsHandler)
+		request := func(authorization string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", "http://localhost:8080/data", nil)
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			return resp
+		}
+		sign := func(claims map[string]interface{}) string {
+			token, err := signer.Sign(claims)
+			So(err, ShouldBeNil)
+			return token
+		}
+
+		Convey("When a request has a valid bearer token, check it is passed on with the token's claims", func() {
+			resp := request("Bearer " + sign(authtest.Claims(region, userPoolId)))
+
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.String(), ShouldEqual, "test-user")
+		})
+
+		Convey("When requests with valid tokens are made, check the keys are retrieved once", func() {
+			token := sign(authtest.Claims(region, userPoolId))
+			So(request("Bearer "+token).Code, ShouldEqual, http.StatusOK)
+			So(request("bearer "+token).Code, ShouldEqual, http.StatusOK)
+			So(atomic.LoadInt32(&keyRequests), ShouldEqual, 1)
+		})
+
+		Convey("When a request has no bearer token, check it is refused", func() {
+			for _, authorization := range []string{"", "Basic dXNlcjpwYXNz", "Bearer "} {
+				resp := request(authorization)
+
+				So(resp.Code, ShouldEqual, http.StatusUnauthorized)
+				So(resp.Header().Get("WWW-Authenticate"), ShouldEqual, "Bearer")
+				So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"MissingToken","description":"Request has no bearer token"}]}`)
+			}
+		})
+
+		Convey("When a request has an expired token, check it is refused saying so", func() {
+			claims := authtest.Claims(region, userPoolId)
+			claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			resp := request("Bearer " + sign(claims))
+
+			So(resp.Code, ShouldEqual, http.StatusUnauthorized)
+			So(resp.Header().Get("WWW-Authenticate"), ShouldStartWith, `Bearer error="invalid_token", error_description="token expired at `)
+			So(resp.Body.String(), ShouldStartWith, `{"errors":[{"code":"TokenExpired"`)
+		})
+
+		Convey("When a request has a token of another user pool, check it is refused", func() {
+			resp := request("Bearer " + sign(authtest.Claims(region, "eu-west-2_xyz")))
+
+			So(resp.Code, ShouldEqual, http.StatusUnauthorized)
+			So(resp.Body.String(), ShouldStartWith, `{"errors":[{"code":"InvalidIssuer"`)
+		})
+
+		Convey("When a request has a token signed by an unknown key, check it is refused", func() {
+			other, err := authtest.NewSigner("xyz")
+			So(err, ShouldBeNil)
+			token, err := other.Sign(authtest.Claims(region, userPoolId))
+			So(err, ShouldBeNil)
+			resp := request("Bearer " + token)
+
+			So(resp.Code, ShouldEqual, http.StatusUnauthorized)
+			So(resp.Body.String(), ShouldStartWith, `{"errors":[{"code":"KeyNotFound"`)
+		})
+	})
+
+	Convey("Given a middleware that requires access tokens of an app client", t, func() {
+		signer, err := authtest.NewSigner("abc")
+		So(err, ShouldBeNil)
+		server := authtest.NewServer(signer)
+		defer server.Close()
+		profiles, err := api.NewCognitoProfiles([]api.CognitoProfile{{Region: region, UserPoolId: userPoolId, AccessToken: &api.AccessTokenRules{ClientIds: []string{"zebedee"}}}})
+		So(err, ShouldBeNil)
+		middleware := New(Config{KeysURL: server.URL, Region: region, UserPoolId: userPoolId, TokenUse: api.TokenUseAccess, Verify: api.VerifyConfig{Profiles: profiles}})
+		claims := authtest.Claims(region, userPoolId)
+		claims["token_use"], claims["client_id"] = "access", "zebedee"
+
+		Convey("When a token of the app client is verified, check it is valid", func() {
+			token, _ := signer.Sign(claims)
+			_, err := middleware.Verify(context.Background(), token)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("When an ID token is verified, check it is not", func() {
+			claims["token_use"] = "id"
+			token, _ := signer.Sign(claims)
+			_, err := middleware.Verify(context.Background(), token)
+			So(err, ShouldResemble, &api.TokenError{Code: api.CodeInvalidTokenUse, Reason: `token_use "id" is not "access"`})
+		})
+	})
+
+	Convey("Given a middleware whose keys cannot be retrieved, check requests are refused with a 500", t, func() {
+		server := httptest.NewServer(http.NotFoundHandler())
+		defer server.Close()
+		signer, err := authtest.NewSigner("abc")
+		So(err, ShouldBeNil)
+		token, _ := signer.Sign(authtest.Claims(region, userPoolId))
+		req := httptest.NewRequest("GET", "http://localhost:8080/data", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+
+		New(Config{KeysURL: server.URL + "/", Region: region, UserPoolId: userPoolId}).Handler(claimsHandler).ServeHTTP(resp, req)
+
+		So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+		So(resp.Body.String(), ShouldEqual, `{"errors":[{"code":"InternalServerError","description":"Failed to verify token"}]}`)
+	})
+}
+
+func TestNew(t *testing.T) {
+	Convey("Given a cache config that leaves the TTL zero, check the rest of it is kept", t, func() {
+		cache := api.CacheConfig{
+			MinTTL:     time.Minute,
+			MaxTTL:     time.Hour,
+			MaxStale:   2 * time.Hour,
+			Conversion: api.ConversionConfig{StrictParsing: true, AllOrNothing: true},
+		}
+
+		m := New(Config{KeysURL: "http://localhost:25999", Region: region, UserPoolId: userPoolId, Cache: cache})
+
+		So(m.cfg.Cache, ShouldResemble, api.CacheConfig{
+			TTL:                DefaultCacheConfig.TTL,
+			MinTTL:             time.Minute,
+			MaxTTL:             time.Hour,
+			MaxStale:           2 * time.Hour,
+			KidRefreshInterval: DefaultCacheConfig.KidRefreshInterval,
+			Conversion:         api.ConversionConfig{StrictParsing: true, AllOrNothing: true},
+		})
+		So(m.retriever.interval, ShouldEqual, DefaultCacheConfig.KidRefreshInterval)
+	})
+
+	Convey("Given a cache config without any of it set, check the defaults are used", t, func() {
+		m := New(Config{KeysURL: "http://localhost:25999", Region: region, UserPoolId: userPoolId})
+
+		So(m.cfg.Cache, ShouldResemble, DefaultCacheConfig)
+	})
+
+	Convey("Given a cache config disabling stale keys and kid requests, check they stay disabled", t, func() {
+		m := New(Config{KeysURL: "http://localhost:25999", Region: region, UserPoolId: userPoolId, Cache: api.CacheConfig{MaxStale: -1, KidRefreshInterval: -1}})
+
+		So(m.cfg.Cache.TTL, ShouldEqual, DefaultCacheConfig.TTL)
+		So(m.cfg.Cache.MaxStale, ShouldEqual, 0)
+		So(m.retriever.allowKidRequest(region+"/"+userPoolId), ShouldBeFalse)
+	})
+}
+
+func TestContext(t *testing.T) {
+	Convey("Given a context without claims, check none are found", t, func() {
+		_, ok := ClaimsFromContext(context.Background())
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Given a context with claims, check they are found", t, func() {
+		claims, ok := ClaimsFromContext(NewContext(context.Background(), map[string]interface{}{"sub": "user"}))
+		So(ok, ShouldBeTrue)
+		So(claims, ShouldResemble, map[string]interface{}{"sub": "user"})
+	})
+}
@@ -0,0 +1,99 @@
+// Package authtest signs tokens with throwaway keys and serves those keys as dp-retrieve-public-signing-keys-aws-cognito
+// does, for testing handlers behind authmiddleware without AWS Cognito
+package authtest
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	b64 "encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-retrieve-public-signing-keys-aws-cognito/api"
+	"github.com/gorilla/mux"
+)
+
+// Signer signs tokens with a throwaway RS256 key, as Cognito does
+type Signer struct {
+	Kid     string
+	private *rsa.PrivateKey
+}
+
+// NewSigner returns a Signer with a newly generated key
+func NewSigner(kid string) (*Signer, error) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{Kid: kid, private: private}, nil
+}
+
+// JWK returns the public key of the signer as a JWK
+func (s *Signer) JWK() api.JsonKey {
+	return api.JsonKey{
+		Alg: "RS256",
+		E:   b64.RawURLEncoding.EncodeToString([]byte{1, 0, 1}),
+		Kid: s.Kid,
+		Kty: "RSA",
+		N:   b64.RawURLEncoding.EncodeToString(s.private.N.Bytes()),
+		Use: "sig",
+	}
+}
+
+// Sign returns a token with the claims, signed by the key
+func (s *Signer) Sign(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(api.TokenHeader{Alg: "RS256", Kid: s.Kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := b64.RawURLEncoding.EncodeToString(header) + "." + b64.RawURLEncoding.EncodeToString(payload)
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.private, crypto.SHA256, digest.Sum(nil))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + b64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Claims returns the claims of a token issued by a user pool that is valid for an hour, to which a test can add
+// claims such as token_use and client_id
+func Claims(region, userPoolId string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss": strings.NewReplacer("{region}", region, "{userPoolId}", userPoolId).Replace(api.DefaultIssuerTemplate),
+		"sub": "test-user",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+}
+
+// NewServer starts the API of dp-retrieve-public-signing-keys-aws-cognito serving the keys of the signers for every
+// user pool. The caller should call Close when finished, to shut it down.
+func NewServer(signers ...*Signer) *httptest.Server {
+	jwks := api.JWKS{Keys: make([]api.JsonKey, len(signers))}
+	for i, signer := range signers {
+		jwks.Keys[i] = signer.JWK()
+	}
+	body, _ := json.Marshal(jwks)
+	r := mux.NewRouter()
+	api.Setup(context.Background(), r, jwksRetriever(body), api.VerifyConfig{})
+	return httptest.NewServer(r)
+}
+
+// jwksRetriever serves the same JWKS for every user pool
+type jwksRetriever []byte
+
+func (jr jwksRetriever) RetrieveJWKS(ctx context.Context, region, userPoolId string, prev api.ResponseMetadata) (*api.JWKSResponse, error) {
+	return &api.JWKSResponse{Body: io.NopCloser(strings.NewReader(string(jr))), StatusCode: http.StatusOK}, nil
+}
@@ -0,0 +1,50 @@
+package authtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ONSdigital/dp-retrieve-public-signing-keys-aws-cognito/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestServer(t *testing.T) {
+	Convey("Given a server with the key of a signer", t, func() {
+		signer, err := NewSigner("abc")
+		So(err, ShouldBeNil)
+		server := NewServer(signer)
+		defer server.Close()
+
+		Convey("When a token the signer signed is verified by the server, check it is valid", func() {
+			claims := Claims("eu-west-2", "eu-west-2_abc")
+			claims["token_use"] = "access"
+			token, err := signer.Sign(claims)
+			So(err, ShouldBeNil)
+			body, _ := json.Marshal(api.VerifyRequest{Token: token, Region: "eu-west-2", UserPoolId: "eu-west-2_abc", TokenUse: "access"})
+
+			resp, err := http.Post(server.URL+"/verify", "application/json", strings.NewReader(string(body)))
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			var verdict api.Verdict
+			So(json.NewDecoder(resp.Body).Decode(&verdict), ShouldBeNil)
+
+			So(verdict.Valid, ShouldBeTrue)
+			So(verdict.Kid, ShouldEqual, "abc")
+			So(verdict.Claims["sub"], ShouldEqual, "test-user")
+		})
+
+		Convey("When the JWKS of any user pool is requested, check it has the signer's key", func() {
+			resp, err := http.Get(server.URL + "/eu-west-1/eu-west-1_xyz?format=jwk")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			var jwks api.JWKS
+			So(json.NewDecoder(resp.Body).Decode(&jwks), ShouldBeNil)
+
+			So(jwks.Keys, ShouldHaveLength, 1)
+			So(jwks.Keys[0].Kid, ShouldEqual, "abc")
+			So(jwks.Keys[0].N, ShouldEqual, signer.JWK().N)
+		})
+	})
+}
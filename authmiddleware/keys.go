@@ -0,0 +1,86 @@
+package authmiddleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/dp-retrieve-public-signing-keys-aws-cognito/api"
+)
+
+// serviceKeys caches the keys of user pools as served by the service. A kid they do not have is looked for with the
+// service's kid endpoint, which refreshes the service's own keys in case Cognito has just rotated it in, and the
+// cached keys are only refreshed once the service has it.
+type serviceKeys struct {
+	*api.CachingJWKSRetriever
+	client   *http.Client
+	keysURL  string
+	interval time.Duration
+	now      func() time.Time
+
+	mu     sync.Mutex
+	forced map[string]time.Time
+}
+
+func newServiceKeys(client *http.Client, keysURL string, cfg api.CacheConfig) *serviceKeys {
+	interval := cfg.KidRefreshInterval
+	// Forced refreshes are limited by RefreshKid, which only forces one when the service has the kid, so the cache
+	// must not skip it for keys fetched within the interval
+	cfg.KidRefreshInterval = time.Nanosecond
+	return &serviceKeys{
+		CachingJWKSRetriever: api.NewCachingJWKSRetriever(api.NewCognitoJWKSRetriever(client, keysURL+"/{region}/{userPoolId}?format=jwk"), cfg),
+		client:               client,
+		keysURL:              keysURL,
+		interval:             interval,
+		now:                  time.Now,
+		forced:               make(map[string]time.Time),
+	}
+}
+
+// RefreshKid asks the service for a kid the cached keys of a user pool do not have, refreshing them if it has it. So
+// that unknown kids cannot flood the service, ErrRefreshSkipped is returned instead if the service was asked for a kid
+// of the user pool within the kid refresh interval, or does not have the kid.
+func (s *serviceKeys) RefreshKid(ctx context.Context, region, userPoolId, kid string) (api.KeySet, error) {
+	if !s.allowKidRequest(region + "/" + userPoolId) {
+		return api.KeySet{}, api.ErrRefreshSkipped
+	}
+	kidURL := s.keysURL + "/" + url.PathEscape(region) + "/" + url.PathEscape(userPoolId) + "/" + url.PathEscape(kid) + "?format=jwk"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, kidURL, nil)
+	if err != nil {
+		return api.KeySet{}, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return api.KeySet{}, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return s.RefreshKeys(ctx, region, userPoolId)
+	case http.StatusNotFound:
+		return api.KeySet{}, api.ErrRefreshSkipped
+	default:
+		return api.KeySet{}, fmt.Errorf("request for kid %s responded with status %d", kid, resp.StatusCode)
+	}
+}
+
+// allowKidRequest reports whether the service may be asked for a kid of a user pool, recording it if so
+func (s *serviceKeys) allowKidRequest(key string) bool {
+	if s.interval <= 0 {
+		return false
+	}
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if forcedAt, ok := s.forced[key]; ok && now.Sub(forcedAt) < s.interval {
+		return false
+	}
+	s.forced[key] = now
+	return true
+}
@@ -0,0 +1,109 @@
+package authmiddleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-retrieve-public-signing-keys-aws-cognito/authmiddleware/authtest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// rotatingService stands in for the service after Cognito has rotated in a key: its keys are cached without the new
+// key until a kid they do not have is requested, which refreshes them
+type rotatingService struct {
+	stale, fresh http.Handler
+
+	mu        sync.Mutex
+	refreshed bool
+	paths     []string
+}
+
+func (s *rotatingService) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	s.paths = append(s.paths, req.URL.RequestURI())
+	isKid := strings.Count(req.URL.Path, "/") > 2
+	if isKid {
+		s.refreshed = true
+	}
+	handler := s.stale
+	if s.refreshed {
+		handler = s.fresh
+	}
+	s.mu.Unlock()
+	handler.ServeHTTP(w, req)
+}
+
+func (s *rotatingService) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.paths...)
+}
+
+func TestServiceKeysRefreshKid(t *testing.T) {
+	Convey("Given a middleware whose keys are cached when Cognito rotates in a key", t, func() {
+		signer, err := authtest.NewSigner("old")
+		So(err, ShouldBeNil)
+		rotated, err := authtest.NewSigner("new/key+=")
+		So(err, ShouldBeNil)
+		stale, fresh := authtest.NewServer(signer), authtest.NewServer(signer, rotated)
+		defer stale.Close()
+		defer fresh.Close()
+		service := &rotatingService{stale: stale.Config.Handler, fresh: fresh.Config.Handler}
+		server := httptest.NewServer(service)
+		defer server.Close()
+		now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+		middleware := New(Config{KeysURL: server.URL, Region: region, UserPoolId: userPoolId})
+		middleware.retriever.now = func() time.Time { return now }
+		handler := middleware.Handler(claimsHandler)
+		request := func(s *authtest.Signer) int {
+			token, err := s.Sign(authtest.Claims(region, userPoolId))
+			So(err, ShouldBeNil)
+			req := httptest.NewRequest("GET", "http://localhost:8080/data", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			return resp.Code
+		}
+		So(request(signer), ShouldEqual, http.StatusOK)
+
+		Convey("When a token signed by the new key is verified, check the service is asked for its kid and it is accepted", func() {
+			So(request(rotated), ShouldEqual, http.StatusOK)
+			So(service.requests(), ShouldResemble, []string{
+				"/eu-west-2/eu-west-2_abc?format=jwk",
+				"/eu-west-2/eu-west-2_abc/new%2Fkey+=?format=jwk",
+				"/eu-west-2/eu-west-2_abc?format=jwk",
+			})
+
+			Convey("Then further tokens signed by it are verified with the cached keys", func() {
+				So(request(rotated), ShouldEqual, http.StatusOK)
+				So(service.requests(), ShouldHaveLength, 3)
+			})
+		})
+
+		Convey("When a token signed by a key the service does not have is verified, check the cached keys are not refreshed", func() {
+			unknown, err := authtest.NewSigner("unknown")
+			So(err, ShouldBeNil)
+			So(request(unknown), ShouldEqual, http.StatusUnauthorized)
+			So(service.requests(), ShouldResemble, []string{
+				"/eu-west-2/eu-west-2_abc?format=jwk",
+				"/eu-west-2/eu-west-2_abc/unknown?format=jwk",
+			})
+
+			Convey("Then the service is not asked for another kid within the refresh interval", func() {
+				now = now.Add(10 * time.Second)
+				So(request(rotated), ShouldEqual, http.StatusUnauthorized)
+				So(service.requests(), ShouldHaveLength, 2)
+			})
+
+			Convey("Then the service is asked for another kid after the refresh interval", func() {
+				now = now.Add(time.Minute)
+				So(request(rotated), ShouldEqual, http.StatusOK)
+				So(service.requests(), ShouldHaveLength, 4)
+			})
+		})
+	})
+}
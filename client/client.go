@@ -0,0 +1,290 @@
+// Package client is a client for the API of dp-retrieve-public-signing-keys-aws-cognito
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/dp-healthcheck/healthcheck"
+	"github.com/ONSdigital/dp-retrieve-public-signing-keys-aws-cognito/api"
+)
+
+// Service is the name of the service the client calls, as reported to the healthcheck
+const Service = "dp-retrieve-public-signing-keys-aws-cognito"
+
+// DefaultCacheTTL is how long the keys of a user pool are cached unless Config sets a TTL of its own
+const DefaultCacheTTL = 5 * time.Minute
+
+// DefaultKidRequestInterval is the least time between requests for kids of a user pool that are not among its cached
+// keys unless Config sets an interval of its own
+const DefaultKidRequestInterval = 30 * time.Second
+
+// DefaultRetryPolicy is how requests that fail transiently are retried unless Config sets a policy of its own
+var DefaultRetryPolicy = api.RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+	Jitter:      0.5,
+}
+
+// Config says where the service is and how the client calls it
+type Config struct {
+	// URL is the URL of the service, e.g. http://localhost:25999
+	URL string
+	// HTTPClient makes the requests, http.DefaultClient if nil
+	HTTPClient *http.Client
+	// CacheTTL is how long the keys of a user pool are cached, DefaultCacheTTL if zero. Less than zero disables caching.
+	CacheTTL time.Duration
+	// Retry is how requests that fail with a network error, a 429 or a 5xx are retried, DefaultRetryPolicy if its
+	// MaxAttempts is zero
+	Retry api.RetryPolicy
+	// KidRequestInterval is the least time between requests to the service for kids of a user pool that are not among
+	// its cached keys, DefaultKidRequestInterval if zero, so that tokens with made-up kids cannot flood the service.
+	// Less than zero never requests them.
+	KidRequestInterval time.Duration
+}
+
+// Client gets the public signing keys of user pools from the service, caching them, and verifies tokens with it
+type Client struct {
+	url         string
+	httpClient  *http.Client
+	cacheTTL    time.Duration
+	retry       api.RetryPolicy
+	kidInterval time.Duration
+	now         func() time.Time
+	random      func() float64
+
+	mu           sync.Mutex
+	entries      map[string]cacheEntry
+	kidRequested map[string]time.Time
+}
+
+type cacheEntry struct {
+	keys      map[string]string
+	fetchedAt time.Time
+}
+
+// ResponseError is an error response from the service
+type ResponseError struct {
+	StatusCode int
+	Errors     []api.Error
+}
+
+func (e *ResponseError) Error() string {
+	descriptions := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		descriptions[i] = err.Code + ": " + err.Description
+	}
+	return fmt.Sprintf("%s responded with %d: %s", Service, e.StatusCode, strings.Join(descriptions, "; "))
+}
+
+// Code returns the code of the first error in the response, if it has one
+func (e *ResponseError) Code() string {
+	if len(e.Errors) == 0 {
+		return ""
+	}
+	return e.Errors[0].Code
+}
+
+// New returns a Client for the service at cfg.URL
+func New(cfg Config) *Client {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	if cfg.CacheTTL == 0 {
+		cfg.CacheTTL = DefaultCacheTTL
+	}
+	if cfg.Retry.MaxAttempts == 0 {
+		cfg.Retry = DefaultRetryPolicy
+	}
+	if cfg.KidRequestInterval == 0 {
+		cfg.KidRequestInterval = DefaultKidRequestInterval
+	}
+	return &Client{
+		url:          strings.TrimSuffix(cfg.URL, "/"),
+		httpClient:   cfg.HTTPClient,
+		cacheTTL:     cfg.CacheTTL,
+		retry:        cfg.Retry,
+		kidInterval:  cfg.KidRequestInterval,
+		now:          time.Now,
+		random:       rand.Float64,
+		entries:      make(map[string]cacheEntry),
+		kidRequested: make(map[string]time.Time),
+	}
+}
+
+// GetKeys returns the keys of a user pool, by kid, each base64 encoded PKIX DER
+func (c *Client) GetKeys(ctx context.Context, region, userPoolId string) (map[string]string, error) {
+	cacheKey := region + "/" + userPoolId
+	if keys, ok := c.cached(cacheKey); ok {
+		return copyKeys(keys), nil
+	}
+	var keys map[string]string
+	if err := c.do(ctx, http.MethodGet, "/"+url.PathEscape(region)+"/"+url.PathEscape(userPoolId), nil, &keys); err != nil {
+		return nil, err
+	}
+	if c.cacheTTL > 0 {
+		c.mu.Lock()
+		c.entries[cacheKey] = cacheEntry{keys: copyKeys(keys), fetchedAt: c.now()}
+		c.mu.Unlock()
+	}
+	return keys, nil
+}
+
+// copyKeys copies keys, so that callers cannot change those cached
+func copyKeys(keys map[string]string) map[string]string {
+	copied := make(map[string]string, len(keys))
+	for kid, key := range keys {
+		copied[kid] = key
+	}
+	return copied
+}
+
+// GetKey returns the key of a user pool with the kid, base64 encoded PKIX DER. A kid that is not among the cached
+// keys is requested from the service, which refreshes its own keys in case it has just been rotated in, and the
+// cached keys are dropped so that it is among them next time. The service is asked for such kids at most once per
+// KidRequestInterval for each user pool; in between, they are not found.
+func (c *Client) GetKey(ctx context.Context, region, userPoolId, kid string) (string, error) {
+	keys, err := c.GetKeys(ctx, region, userPoolId)
+	if err != nil {
+		return "", err
+	}
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	if !c.allowKidRequest(region + "/" + userPoolId) {
+		return "", &ResponseError{StatusCode: http.StatusNotFound, Errors: []api.Error{{
+			Code:        api.CodeKeyNotFound,
+			Description: fmt.Sprintf("Key %s not found in user pool %s in region %s. Try changing the kid.", kid, userPoolId, region),
+		}}}
+	}
+	var found map[string]string
+	if err := c.do(ctx, http.MethodGet, "/"+url.PathEscape(region)+"/"+url.PathEscape(userPoolId)+"/"+url.PathEscape(kid), nil, &found); err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	delete(c.entries, region+"/"+userPoolId)
+	c.mu.Unlock()
+	return found[kid], nil
+}
+
+// Verify asks the service to verify a token, returning its Verdict. A token that fails verification is not an
+// error: the Verdict says why it failed.
+func (c *Client) Verify(ctx context.Context, verifyReq api.VerifyRequest) (*api.Verdict, error) {
+	body, err := json.Marshal(verifyReq)
+	if err != nil {
+		return nil, err
+	}
+	var verdict api.Verdict
+	if err := c.do(ctx, http.MethodPost, "/verify", body, &verdict); err != nil {
+		return nil, err
+	}
+	return &verdict, nil
+}
+
+// Checker reports the health of the service to the consumer's healthcheck: OK if it is healthy, WARNING if it
+// reports a warning and CRITICAL if it reports itself critical or cannot be reached
+func (c *Client) Checker(ctx context.Context, state *healthcheck.CheckState) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/health", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return state.Update(healthcheck.StatusCritical, fmt.Sprintf("%s is unreachable: %s", Service, err.Error()), 0)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return state.Update(healthcheck.StatusOK, Service+" is ok", resp.StatusCode)
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return state.Update(healthcheck.StatusWarning, Service+" is degraded, but at least partially functioning", resp.StatusCode)
+	default:
+		return state.Update(healthcheck.StatusCritical, Service+" functionality is unavailable or non-functioning", resp.StatusCode)
+	}
+}
+
+// allowKidRequest reports whether the service may be asked for a kid of a user pool that is not among its cached
+// keys, recording the request if so
+func (c *Client) allowKidRequest(cacheKey string) bool {
+	if c.kidInterval < 0 {
+		return false
+	}
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if requestedAt, ok := c.kidRequested[cacheKey]; ok && now.Sub(requestedAt) < c.kidInterval {
+		return false
+	}
+	c.kidRequested[cacheKey] = now
+	return true
+}
+
+// cached returns the cached keys of a user pool, if they have not expired
+func (c *Client) cached(cacheKey string) (map[string]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[cacheKey]
+	if !ok || c.now().Sub(entry.fetchedAt) >= c.cacheTTL {
+		return nil, false
+	}
+	return entry.keys, true
+}
+
+// do makes a request to the service, retrying it as the policy allows, and decodes a 200 response into result. Any
+// other response is returned as a ResponseError.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, result interface{}) error {
+	for attempt := 1; ; attempt++ {
+		resp, err := c.attempt(ctx, method, path, body)
+		if attempt >= c.retry.MaxAttempts || ctx.Err() != nil || (err == nil && !api.IsRetryableStatus(resp.StatusCode)) {
+			if err != nil {
+				return err
+			}
+			return decodeResponse(resp, result)
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(c.retry.Backoff(attempt, c.random())):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	return c.httpClient.Do(req)
+}
+
+func decodeResponse(resp *http.Response, result interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var errResp api.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return &ResponseError{StatusCode: resp.StatusCode, Errors: errResp.Errors}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("error decoding response from %s: %s", Service, err.Error())
+	}
+	return nil
+}
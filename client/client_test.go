@@ -0,0 +1,341 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-healthcheck/healthcheck"
+	"github.com/ONSdigital/dp-retrieve-public-signing-keys-aws-cognito/api"
+	"github.com/ONSdigital/dp-retrieve-public-signing-keys-aws-cognito/authmiddleware/authtest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var ctx = context.Background()
+
+// fastRetries retries without waiting long, so that tests of retries are quick
+var fastRetries = api.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+// countingServer starts a server that counts the requests it passes on to the handler
+func countingServer(handler http.Handler) (*httptest.Server, *int32) {
+	var requests int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		handler.ServeHTTP(w, req)
+	})), &requests
+}
+
+func TestClientGetKeys(t *testing.T) {
+	Convey("Given a client of the service serving the key of a signer", t, func() {
+		signer, err := authtest.NewSigner("j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8=")
+		So(err, ShouldBeNil)
+		keysServer := authtest.NewServer(signer)
+		defer keysServer.Close()
+		server, requests := countingServer(keysServer.Config.Handler)
+		defer server.Close()
+		now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+		c := New(Config{URL: server.URL + "/", CacheTTL: time.Minute})
+		c.now = func() time.Time { return now }
+
+		Convey("When the keys of a user pool are requested, check they are returned by kid", func() {
+			keys, err := c.GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+
+			So(err, ShouldBeNil)
+			So(keys, ShouldHaveLength, 1)
+			So(keys[signer.Kid], ShouldStartWith, "MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA")
+		})
+
+		Convey("When the keys of a user pool are requested again within the TTL, check they are served from the cache", func() {
+			c.GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+			now = now.Add(30 * time.Second)
+			_, err := c.GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+
+			So(err, ShouldBeNil)
+			So(atomic.LoadInt32(requests), ShouldEqual, 1)
+		})
+
+		Convey("When the keys of a user pool are requested again after the TTL, check they are requested again", func() {
+			c.GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+			now = now.Add(time.Minute)
+			_, err := c.GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+
+			So(err, ShouldBeNil)
+			So(atomic.LoadInt32(requests), ShouldEqual, 2)
+		})
+
+		Convey("When keys returned are changed, check the cached keys are not", func() {
+			keys, _ := c.GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+			delete(keys, signer.Kid)
+			keys, _ = c.GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+			keys["other"] = "added"
+			keys, err := c.GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+
+			So(err, ShouldBeNil)
+			So(keys, ShouldHaveLength, 1)
+			So(keys[signer.Kid], ShouldStartWith, "MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA")
+			So(atomic.LoadInt32(requests), ShouldEqual, 1)
+		})
+
+		Convey("When the keys of another user pool are requested, check they are requested", func() {
+			c.GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+			c.GetKeys(ctx, "eu-west-2", "eu-west-2_xyz")
+
+			So(atomic.LoadInt32(requests), ShouldEqual, 2)
+		})
+	})
+
+	Convey("Given a client with caching disabled, check every request for keys is made to the service", t, func() {
+		signer, err := authtest.NewSigner("abc")
+		So(err, ShouldBeNil)
+		keysServer := authtest.NewServer(signer)
+		defer keysServer.Close()
+		server, requests := countingServer(keysServer.Config.Handler)
+		defer server.Close()
+		c := New(Config{URL: server.URL, CacheTTL: -1})
+
+		c.GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+		c.GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+
+		So(atomic.LoadInt32(requests), ShouldEqual, 2)
+	})
+
+	Convey("Given a service that does not know the user pool, check the error response is returned", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[{"code":"UserPoolNotFound","description":"User pool eu-west-2_abc in region eu-west-2 not found. Try changing the region or user pool ID."}]}`))
+		}))
+		defer server.Close()
+
+		_, err := New(Config{URL: server.URL}).GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+
+		respErr, ok := err.(*ResponseError)
+		So(ok, ShouldBeTrue)
+		So(respErr.StatusCode, ShouldEqual, http.StatusNotFound)
+		So(respErr.Code(), ShouldEqual, api.CodeUserPoolNotFound)
+		So(err.Error(), ShouldEqual, "dp-retrieve-public-signing-keys-aws-cognito responded with 404: UserPoolNotFound: User pool eu-west-2_abc in region eu-west-2 not found. Try changing the region or user pool ID.")
+	})
+}
+
+func TestClientGetKey(t *testing.T) {
+	Convey("Given a client of the service serving the key of a signer", t, func() {
+		signer, err := authtest.NewSigner("j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8=")
+		So(err, ShouldBeNil)
+		keysServer := authtest.NewServer(signer)
+		defer keysServer.Close()
+		server, requests := countingServer(keysServer.Config.Handler)
+		defer server.Close()
+		c := New(Config{URL: server.URL})
+
+		Convey("When the key with its kid is requested, check it is returned", func() {
+			keys, _ := c.GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+			key, err := c.GetKey(ctx, "eu-west-2", "eu-west-2_abc", signer.Kid)
+
+			So(err, ShouldBeNil)
+			So(key, ShouldEqual, keys[signer.Kid])
+			So(atomic.LoadInt32(requests), ShouldEqual, 1)
+		})
+
+		Convey("When a key with another kid is requested, check the service is asked for it and it is not found", func() {
+			_, err := c.GetKey(ctx, "eu-west-2", "eu-west-2_abc", "unknown")
+
+			So(err, ShouldHaveSameTypeAs, &ResponseError{})
+			So(err.(*ResponseError).Code(), ShouldEqual, api.CodeKeyNotFound)
+			So(atomic.LoadInt32(requests), ShouldEqual, 2)
+		})
+
+		Convey("When keys with other kids are requested within the kid request interval, check the service is asked only once", func() {
+			now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+			c.now = func() time.Time { return now }
+			c.GetKey(ctx, "eu-west-2", "eu-west-2_abc", "unknown")
+			now = now.Add(10 * time.Second)
+			_, err := c.GetKey(ctx, "eu-west-2", "eu-west-2_abc", "forged")
+
+			So(err, ShouldHaveSameTypeAs, &ResponseError{})
+			So(err.(*ResponseError).StatusCode, ShouldEqual, http.StatusNotFound)
+			So(err.Error(), ShouldEqual, "dp-retrieve-public-signing-keys-aws-cognito responded with 404: KeyNotFound: Key forged not found in user pool eu-west-2_abc in region eu-west-2. Try changing the kid.")
+			So(atomic.LoadInt32(requests), ShouldEqual, 2)
+
+			Convey("Then the service is asked for kids of other user pools", func() {
+				c.GetKey(ctx, "eu-west-2", "eu-west-2_xyz", "forged")
+				So(atomic.LoadInt32(requests), ShouldEqual, 4)
+			})
+
+			Convey("Then the service is asked again once the interval has passed", func() {
+				now = now.Add(DefaultKidRequestInterval)
+				c.GetKey(ctx, "eu-west-2", "eu-west-2_abc", "forged")
+				So(atomic.LoadInt32(requests), ShouldEqual, 3)
+			})
+		})
+	})
+
+	Convey("Given a client that never requests kids that are not cached, check they are not found without asking the service", t, func() {
+		signer, err := authtest.NewSigner("abc")
+		So(err, ShouldBeNil)
+		keysServer := authtest.NewServer(signer)
+		defer keysServer.Close()
+		server, requests := countingServer(keysServer.Config.Handler)
+		defer server.Close()
+		c := New(Config{URL: server.URL, KidRequestInterval: -1})
+
+		_, err = c.GetKey(ctx, "eu-west-2", "eu-west-2_abc", "unknown")
+
+		So(err.(*ResponseError).Code(), ShouldEqual, api.CodeKeyNotFound)
+		So(atomic.LoadInt32(requests), ShouldEqual, 1)
+	})
+
+	Convey("Given a service that has rotated in a key since its keys were cached, check the key is returned and the cache dropped", t, func() {
+		signer, err := authtest.NewSigner("old")
+		So(err, ShouldBeNil)
+		rotated, err := authtest.NewSigner("rotated")
+		So(err, ShouldBeNil)
+		oldServer, newServer := authtest.NewServer(signer), authtest.NewServer(signer, rotated)
+		defer oldServer.Close()
+		defer newServer.Close()
+		var current atomic.Value
+		current.Store(oldServer.Config.Handler)
+		server, requests := countingServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			current.Load().(http.Handler).ServeHTTP(w, req)
+		}))
+		defer server.Close()
+		c := New(Config{URL: server.URL})
+		c.GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+		current.Store(newServer.Config.Handler)
+
+		key, err := c.GetKey(ctx, "eu-west-2", "eu-west-2_abc", "rotated")
+		So(err, ShouldBeNil)
+		So(key, ShouldNotBeEmpty)
+		keys, err := c.GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+		So(err, ShouldBeNil)
+		So(keys["rotated"], ShouldEqual, key)
+		So(atomic.LoadInt32(requests), ShouldEqual, 3)
+	})
+}
+
+func TestClientVerify(t *testing.T) {
+	Convey("Given a client of the service serving the key of a signer", t, func() {
+		signer, err := authtest.NewSigner("abc")
+		So(err, ShouldBeNil)
+		server := authtest.NewServer(signer)
+		defer server.Close()
+		c := New(Config{URL: server.URL})
+
+		Convey("When a token the signer signed is verified, check it is valid", func() {
+			token, _ := signer.Sign(authtest.Claims("eu-west-2", "eu-west-2_abc"))
+			verdict, err := c.Verify(ctx, api.VerifyRequest{Token: token, Region: "eu-west-2", UserPoolId: "eu-west-2_abc"})
+
+			So(err, ShouldBeNil)
+			So(verdict.Valid, ShouldBeTrue)
+			So(verdict.Claims["sub"], ShouldEqual, "test-user")
+		})
+
+		Convey("When a token issued by another user pool is verified, check it is invalid saying so", func() {
+			token, _ := signer.Sign(authtest.Claims("eu-west-2", "eu-west-2_xyz"))
+			verdict, err := c.Verify(ctx, api.VerifyRequest{Token: token, Region: "eu-west-2", UserPoolId: "eu-west-2_abc"})
+
+			So(err, ShouldBeNil)
+			So(verdict.Valid, ShouldBeFalse)
+			So(verdict.Error.Code, ShouldEqual, api.CodeInvalidIssuer)
+		})
+
+		Convey("When a request without a token is made, check the error response is returned", func() {
+			_, err := c.Verify(ctx, api.VerifyRequest{Region: "eu-west-2", UserPoolId: "eu-west-2_abc"})
+
+			So(err, ShouldResemble, &ResponseError{StatusCode: http.StatusBadRequest, Errors: []api.Error{{Code: api.CodeInvalidRequest, Description: "token, region and user_pool_id are required"}}})
+		})
+	})
+}
+
+func TestClientRetries(t *testing.T) {
+	Convey("Given a service that fails twice before succeeding", t, func() {
+		signer, err := authtest.NewSigner("abc")
+		So(err, ShouldBeNil)
+		keysServer := authtest.NewServer(signer)
+		defer keysServer.Close()
+		var failures int32 = 2
+		server, requests := countingServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			keysServer.Config.Handler.ServeHTTP(w, req)
+		}))
+		defer server.Close()
+
+		Convey("When keys are requested by a client that makes three attempts, check they are returned", func() {
+			keys, err := New(Config{URL: server.URL, Retry: fastRetries}).GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+
+			So(err, ShouldBeNil)
+			So(keys, ShouldContainKey, "abc")
+			So(atomic.LoadInt32(requests), ShouldEqual, 3)
+		})
+
+		Convey("When keys are requested by a client that makes two attempts, check the last failure is returned", func() {
+			_, err := New(Config{URL: server.URL, Retry: api.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}}).GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+
+			So(err, ShouldResemble, &ResponseError{StatusCode: http.StatusServiceUnavailable})
+			So(atomic.LoadInt32(requests), ShouldEqual, 2)
+		})
+	})
+
+	Convey("Given a service that does not know the user pool, check the request is not retried", t, func() {
+		server, requests := countingServer(http.NotFoundHandler())
+		defer server.Close()
+
+		_, err := New(Config{URL: server.URL, Retry: fastRetries}).GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+
+		So(err, ShouldHaveSameTypeAs, &ResponseError{})
+		So(atomic.LoadInt32(requests), ShouldEqual, 1)
+	})
+
+	Convey("Given a service that cannot be reached, check the request fails after every attempt", t, func() {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		_, err := New(Config{URL: server.URL, Retry: fastRetries}).GetKeys(ctx, "eu-west-2", "eu-west-2_abc")
+
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestClientChecker(t *testing.T) {
+	Convey("Given services responding to healthchecks with each status, check each is reported", t, func() {
+		tests := []struct {
+			statusCode int
+			status     string
+			message    string
+		}{
+			{http.StatusOK, healthcheck.StatusOK, "dp-retrieve-public-signing-keys-aws-cognito is ok"},
+			{http.StatusTooManyRequests, healthcheck.StatusWarning, "dp-retrieve-public-signing-keys-aws-cognito is degraded, but at least partially functioning"},
+			{http.StatusInternalServerError, healthcheck.StatusCritical, "dp-retrieve-public-signing-keys-aws-cognito functionality is unavailable or non-functioning"},
+		}
+		for _, test := range tests {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/health", func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(test.statusCode)
+			})
+			server := httptest.NewServer(mux)
+			state := healthcheck.NewCheckState(Service)
+
+			So(New(Config{URL: server.URL}).Checker(ctx, state), ShouldBeNil)
+			server.Close()
+
+			So(state.Status(), ShouldEqual, test.status)
+			So(state.StatusCode(), ShouldEqual, test.statusCode)
+			So(state.Message(), ShouldEqual, test.message)
+		}
+	})
+
+	Convey("Given a service that cannot be reached, check it is reported critical", t, func() {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		state := healthcheck.NewCheckState(Service)
+
+		So(New(Config{URL: server.URL}).Checker(ctx, state), ShouldBeNil)
+
+		So(state.Status(), ShouldEqual, healthcheck.StatusCritical)
+		So(state.Message(), ShouldStartWith, "dp-retrieve-public-signing-keys-aws-cognito is unreachable: ")
+	})
+}
@@ -0,0 +1,18 @@
+package client
+
+import (
+	"context"
+
+	"github.com/ONSdigital/dp-healthcheck/healthcheck"
+	"github.com/ONSdigital/dp-retrieve-public-signing-keys-aws-cognito/api"
+)
+
+//go:generate moq -out mock/client.go -pkg mock . KeysClient
+
+// KeysClient defines the methods of Client, so that consumers can mock it
+type KeysClient interface {
+	GetKeys(ctx context.Context, region, userPoolId string) (map[string]string, error)
+	GetKey(ctx context.Context, region, userPoolId, kid string) (string, error)
+	Verify(ctx context.Context, verifyReq api.VerifyRequest) (*api.Verdict, error)
+	Checker(ctx context.Context, state *healthcheck.CheckState) error
+}
@@ -12,6 +12,35 @@ type Config struct {
 	GracefulShutdownTimeout    time.Duration `envconfig:"GRACEFUL_SHUTDOWN_TIMEOUT"`
 	HealthCheckInterval        time.Duration `envconfig:"HEALTHCHECK_INTERVAL"`
 	HealthCheckCriticalTimeout time.Duration `envconfig:"HEALTHCHECK_CRITICAL_TIMEOUT"`
+	JWKSURLTemplate            string        `envconfig:"JWKS_URL_TEMPLATE"`
+	JWKSConnectTimeout         time.Duration `envconfig:"JWKS_CONNECT_TIMEOUT"`
+	JWKSTLSHandshakeTimeout    time.Duration `envconfig:"JWKS_TLS_HANDSHAKE_TIMEOUT"`
+	JWKSRequestTimeout         time.Duration `envconfig:"JWKS_REQUEST_TIMEOUT"`
+	JWKSRetryMaxAttempts       int           `envconfig:"JWKS_RETRY_MAX_ATTEMPTS"`
+	JWKSRetryBaseDelay         time.Duration `envconfig:"JWKS_RETRY_BASE_DELAY"`
+	JWKSRetryMaxDelay          time.Duration `envconfig:"JWKS_RETRY_MAX_DELAY"`
+	JWKSRetryJitter            float64       `envconfig:"JWKS_RETRY_JITTER"`
+	JWKSBreakerThreshold       int           `envconfig:"JWKS_BREAKER_FAILURE_THRESHOLD"`
+	JWKSBreakerCoolDown        time.Duration `envconfig:"JWKS_BREAKER_COOL_DOWN"`
+	JWKSMaxBodySize            int64         `envconfig:"JWKS_MAX_BODY_SIZE"`
+	JWKSStrictParsing          bool          `envconfig:"JWKS_STRICT_PARSING"`
+	JWKSAllOrNothing           bool          `envconfig:"JWKS_CONVERSION_ALL_OR_NOTHING"`
+	JWKSStrictKids             bool          `envconfig:"JWKS_STRICT_KIDS"`
+	JWKSCABundle               string        `envconfig:"JWKS_CA_BUNDLE"`
+	JWKSPolicyMinRSABits       int           `envconfig:"JWKS_POLICY_MIN_RSA_BITS"`
+	JWKSPolicyAllowedAlgs      []string      `envconfig:"JWKS_POLICY_ALLOWED_ALGS"`
+	JWKSPolicyRequireSigUse    bool          `envconfig:"JWKS_POLICY_REQUIRE_SIG_USE"`
+	JWKSPolicyWeakExponents    []int         `envconfig:"JWKS_POLICY_WEAK_EXPONENTS"`
+	JWKSPolicyAction           string        `envconfig:"JWKS_POLICY_ACTION"`
+	JWKSCacheTTL               time.Duration `envconfig:"JWKS_CACHE_TTL"`
+	JWKSCacheMinTTL            time.Duration `envconfig:"JWKS_CACHE_MIN_TTL"`
+	JWKSCacheMaxTTL            time.Duration `envconfig:"JWKS_CACHE_MAX_TTL"`
+	JWKSCacheMaxStale          time.Duration `envconfig:"JWKS_CACHE_MAX_STALE"`
+	JWKSRefreshInterval        time.Duration `envconfig:"JWKS_REFRESH_INTERVAL"`
+	JWKSKidRefreshInterval     time.Duration `envconfig:"JWKS_KID_REFRESH_INTERVAL"`
+	JWTIssuerTemplate          string        `envconfig:"JWT_ISSUER_TEMPLATE"`
+	JWTLeeway                  time.Duration `envconfig:"JWT_LEEWAY"`
+	JWTCognitoProfiles         string        `envconfig:"JWT_COGNITO_PROFILES"`
 }
 
 var cfg *Config
@@ -28,6 +57,35 @@ func Get() (*Config, error) {
 		GracefulShutdownTimeout:    5 * time.Second,
 		HealthCheckInterval:        30 * time.Second,
 		HealthCheckCriticalTimeout: 90 * time.Second,
+		JWKSURLTemplate:            "",
+		JWKSConnectTimeout:         5 * time.Second,
+		JWKSTLSHandshakeTimeout:    5 * time.Second,
+		JWKSRequestTimeout:         10 * time.Second,
+		JWKSRetryMaxAttempts:       3,
+		JWKSRetryBaseDelay:         100 * time.Millisecond,
+		JWKSRetryMaxDelay:          2 * time.Second,
+		JWKSRetryJitter:            0.5,
+		JWKSBreakerThreshold:       5,
+		JWKSBreakerCoolDown:        30 * time.Second,
+		JWKSMaxBodySize:            1 << 20,
+		JWKSStrictParsing:          false,
+		JWKSAllOrNothing:           false,
+		JWKSStrictKids:             false,
+		JWKSCABundle:               "",
+		JWKSPolicyMinRSABits:       2048,
+		JWKSPolicyAllowedAlgs:      nil,
+		JWKSPolicyRequireSigUse:    false,
+		JWKSPolicyWeakExponents:    []int{3},
+		JWKSPolicyAction:           "drop",
+		JWKSCacheTTL:               5 * time.Minute,
+		JWKSCacheMinTTL:            0,
+		JWKSCacheMaxTTL:            0,
+		JWKSCacheMaxStale:          24 * time.Hour,
+		JWKSRefreshInterval:        time.Minute,
+		JWKSKidRefreshInterval:     30 * time.Second,
+		JWTIssuerTemplate:          "",
+		JWTLeeway:                  0,
+		JWTCognitoProfiles:         "",
 	}
 
 	return cfg, envconfig.Process("", cfg)
@@ -28,6 +28,35 @@ func TestConfig(t *testing.T) {
 					GracefulShutdownTimeout:    5 * time.Second,
 					HealthCheckInterval:        30 * time.Second,
 					HealthCheckCriticalTimeout: 90 * time.Second,
+					JWKSURLTemplate:            "",
+					JWKSConnectTimeout:         5 * time.Second,
+					JWKSTLSHandshakeTimeout:    5 * time.Second,
+					JWKSRequestTimeout:         10 * time.Second,
+					JWKSRetryMaxAttempts:       3,
+					JWKSRetryBaseDelay:         100 * time.Millisecond,
+					JWKSRetryMaxDelay:          2 * time.Second,
+					JWKSRetryJitter:            0.5,
+					JWKSBreakerThreshold:       5,
+					JWKSBreakerCoolDown:        30 * time.Second,
+					JWKSMaxBodySize:            1 << 20,
+					JWKSStrictParsing:          false,
+					JWKSAllOrNothing:           false,
+					JWKSStrictKids:             false,
+					JWKSCABundle:               "",
+					JWKSPolicyMinRSABits:       2048,
+					JWKSPolicyAllowedAlgs:      nil,
+					JWKSPolicyRequireSigUse:    false,
+					JWKSPolicyWeakExponents:    []int{3},
+					JWKSPolicyAction:           "drop",
+					JWKSCacheTTL:               5 * time.Minute,
+					JWKSCacheMinTTL:            0,
+					JWKSCacheMaxTTL:            0,
+					JWKSCacheMaxStale:          24 * time.Hour,
+					JWKSRefreshInterval:        time.Minute,
+					JWKSKidRefreshInterval:     30 * time.Second,
+					JWTIssuerTemplate:          "",
+					JWTLeeway:                  0,
+					JWTCognitoProfiles:         "",
 				})
 			})
 
@@ -1,7 +0,0 @@
-Feature: Helloworld
-
-
-
-  Scenario: Posting and checking a response
-    When I GET "/hello"
-    Then I should receive a hello-world response
\ No newline at end of file
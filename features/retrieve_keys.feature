@@ -0,0 +1,147 @@
+Feature: Retrieve public signing keys
+
+  Background:
+    Given Cognito has the following JWKS for user pool "eu-west-2_abc" in region "eu-west-2":
+      """
+      {
+        "keys": [
+          {
+            "alg": "RS256",
+            "e": "AQAB",
+            "kid": "j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8=",
+            "kty": "RSA",
+            "n": "vBvi--N-F9MQO81xh71jIbkx81w4_sGhbztTJgIdhycV-lMzG6y3dMBWo9eRsFJuRs3MUFElmRrTVxc7EPWNQGQjUyPFW0_CnPPoGBCwgCyWtpNs5EHAkCHXsfryHb6LbJxH9LEbwOQCHR25_Bnqo_NeXSBJtvUabq3cTUgdOPc61Hskq-m19M1u7u1xu7b5DHD308Qyz3OhaEHx3cLL2za-mKxHe0VDe3sa5UfdaliTdBypFWJgNl6TsxF_G83fksgb3bVchzW45pu4dEhtNLqgXejH2-GwU8YRaAguKGW7dO_v-5uwLgDYQG9wgtAwLIMiXsFU7muig2pJEtlG2w",
+            "use": "sig"
+          }
+        ]
+      }
+      """
+
+  Scenario: Retrieving the public keys of a user pool
+    When I GET "/eu-west-2/eu-west-2_abc"
+    Then the HTTP status code should be "200"
+    And I should receive the following JSON response:
+      """
+      {
+        "j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8=": "MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAvBvi++N+F9MQO81xh71jIbkx81w4/sGhbztTJgIdhycV+lMzG6y3dMBWo9eRsFJuRs3MUFElmRrTVxc7EPWNQGQjUyPFW0/CnPPoGBCwgCyWtpNs5EHAkCHXsfryHb6LbJxH9LEbwOQCHR25/Bnqo/NeXSBJtvUabq3cTUgdOPc61Hskq+m19M1u7u1xu7b5DHD308Qyz3OhaEHx3cLL2za+mKxHe0VDe3sa5UfdaliTdBypFWJgNl6TsxF/G83fksgb3bVchzW45pu4dEhtNLqgXejH2+GwU8YRaAguKGW7dO/v+5uwLgDYQG9wgtAwLIMiXsFU7muig2pJEtlG2wIDAQAB"
+      }
+      """
+
+  Scenario: Retrieving the public keys of a user pool as PEM
+    When I GET "/eu-west-2/eu-west-2_abc?format=pem"
+    Then the HTTP status code should be "200"
+    And the response header "Content-Type" should be "application/x-pem-file; charset=utf-8"
+
+  Scenario: Retrieving the public keys of a user pool in a format that is not served
+    When I GET "/eu-west-2/eu-west-2_abc?format=xml"
+    Then the HTTP status code should be "400"
+
+  Scenario: Retrieving the public keys of a user pool with their metadata
+    When I GET "/v2/eu-west-2/eu-west-2_abc"
+    Then the HTTP status code should be "200"
+    And I should receive the following JSON response:
+      """
+      {
+        "keys": [
+          {
+            "kid": "j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8=",
+            "alg": "RS256",
+            "use": "sig",
+            "kty": "RSA",
+            "bits": 2048,
+            "thumbprint": "5ur0AJvoVqWXlP3eUZWVld3cuUkirE619M49RfhH4LE",
+            "key": "MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAvBvi++N+F9MQO81xh71jIbkx81w4/sGhbztTJgIdhycV+lMzG6y3dMBWo9eRsFJuRs3MUFElmRrTVxc7EPWNQGQjUyPFW0/CnPPoGBCwgCyWtpNs5EHAkCHXsfryHb6LbJxH9LEbwOQCHR25/Bnqo/NeXSBJtvUabq3cTUgdOPc61Hskq+m19M1u7u1xu7b5DHD308Qyz3OhaEHx3cLL2za+mKxHe0VDe3sa5UfdaliTdBypFWJgNl6TsxF/G83fksgb3bVchzW45pu4dEhtNLqgXejH2+GwU8YRaAguKGW7dO/v+5uwLgDYQG9wgtAwLIMiXsFU7muig2pJEtlG2wIDAQAB"
+          }
+        ]
+      }
+      """
+
+  Scenario: Retrieving a single public key of a user pool by kid
+    When I GET "/eu-west-2/eu-west-2_abc/j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8="
+    Then the HTTP status code should be "200"
+    And I should receive the following JSON response:
+      """
+      {
+        "j+diD4wBP/VZ4+X51XGRdI8Vi0CNV0OpEefKl1ge3A8=": "MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAvBvi++N+F9MQO81xh71jIbkx81w4/sGhbztTJgIdhycV+lMzG6y3dMBWo9eRsFJuRs3MUFElmRrTVxc7EPWNQGQjUyPFW0/CnPPoGBCwgCyWtpNs5EHAkCHXsfryHb6LbJxH9LEbwOQCHR25/Bnqo/NeXSBJtvUabq3cTUgdOPc61Hskq+m19M1u7u1xu7b5DHD308Qyz3OhaEHx3cLL2za+mKxHe0VDe3sa5UfdaliTdBypFWJgNl6TsxF/G83fksgb3bVchzW45pu4dEhtNLqgXejH2+GwU8YRaAguKGW7dO/v+5uwLgDYQG9wgtAwLIMiXsFU7muig2pJEtlG2wIDAQAB"
+      }
+      """
+
+  Scenario: Retrieving a public key of a user pool by a kid it does not have
+    When I GET "/eu-west-2/eu-west-2_abc/unknown"
+    Then the HTTP status code should be "404"
+    And I should receive the following JSON response:
+      """
+      {
+        "errors": [
+          {
+            "code": "KeyNotFound",
+            "description": "Key unknown not found in user pool eu-west-2_abc in region eu-west-2. Try changing the kid."
+          }
+        ]
+      }
+      """
+
+  Scenario: Verifying a malformed token
+    When I POST "/verify"
+      """
+      {"token": "abc", "region": "eu-west-2", "user_pool_id": "eu-west-2_abc"}
+      """
+    Then the HTTP status code should be "200"
+    And I should receive the following JSON response:
+      """
+      {
+        "valid": false,
+        "error": {
+          "code": "TokenMalformed",
+          "description": "token must have 3 parts, has 1"
+        }
+      }
+      """
+
+  Scenario: Repeat requests for the same user pool are served from the cache
+    When I GET "/eu-west-2/eu-west-2_abc"
+    And I GET "/eu-west-2/eu-west-2_abc"
+    Then the HTTP status code should be "200"
+    And Cognito should have been asked for the JWKS of user pool "eu-west-2_abc" in region "eu-west-2" 1 time
+
+  Scenario: Retrieving the public keys of an unknown user pool
+    When I GET "/eu-west-2/eu-west-2_xyz"
+    Then the HTTP status code should be "404"
+    And the response header "Content-Type" should be "application/json; charset=utf-8"
+    And I should receive the following JSON response:
+      """
+      {
+        "errors": [
+          {
+            "code": "UserPoolNotFound",
+            "description": "User pool eu-west-2_xyz in region eu-west-2 not found. Try changing the region or user pool ID."
+          }
+        ]
+      }
+      """
+
+  Scenario: Retrieving the public keys of a user pool that signs with an elliptic-curve key
+    Given Cognito has the following JWKS for user pool "eu-west-2_ec" in region "eu-west-2":
+      """
+      {
+        "keys": [
+          {
+            "alg": "ES256",
+            "kid": "ec-key",
+            "kty": "EC",
+            "crv": "P-256",
+            "x": "f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU",
+            "y": "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0",
+            "use": "sig"
+          }
+        ]
+      }
+      """
+    When I GET "/eu-west-2/eu-west-2_ec"
+    Then the HTTP status code should be "200"
+    And I should receive the following JSON response:
+      """
+      {
+        "ec-key": "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEf83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEXH8UTNG72bfocs3+257rn0s2ldbqkLJK2KRiMohYjlrQ=="
+      }
+      """
@@ -20,6 +20,7 @@ type Component struct {
 	HTTPServer     *http.Server
 	ServiceRunning bool
 	apiFeature     *componenttest.APIFeature
+	FakeCognito    *FakeCognito
 }
 
 func NewComponent() (*Component, error) {
@@ -37,6 +38,9 @@ func NewComponent() (*Component, error) {
 		return nil, err
 	}
 
+	c.FakeCognito = NewFakeCognito()
+	c.Config.JWKSURLTemplate = c.FakeCognito.URLTemplate()
+
 	initMock := &mock.InitialiserMock{
 		DoGetHealthCheckFunc: c.DoGetHealthcheckOk,
 		DoGetHTTPServerFunc:  c.DoGetHTTPServer,
@@ -51,6 +55,7 @@ func NewComponent() (*Component, error) {
 
 func (c *Component) Reset() *Component {
 	c.apiFeature.Reset()
+	c.FakeCognito.Reset()
 	return c
 }
 
@@ -63,6 +68,9 @@ func (c *Component) Close() error {
 }
 
 func (c *Component) InitialiseService() (http.Handler, error) {
+	if c.ServiceRunning {
+		return c.HTTPServer.Handler, nil
+	}
 	var err error
 	c.svc, err = service.Run(context.Background(), c.Config, c.svcList, "1", "", "", c.errorChan)
 	if err != nil {
@@ -0,0 +1,75 @@
+package steps
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// FakeCognito stands in for AWS Cognito, serving the JWKS of the user pools it has been given
+type FakeCognito struct {
+	server *httptest.Server
+
+	mu    sync.Mutex
+	jwks  map[string]string
+	calls map[string]int
+}
+
+// NewFakeCognito starts a FakeCognito with no user pools
+func NewFakeCognito() *FakeCognito {
+	f := &FakeCognito{}
+	f.Reset()
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveJWKS))
+	return f
+}
+
+// URLTemplate returns the JWKS URL template for the service to request JWKS from the FakeCognito
+func (f *FakeCognito) URLTemplate() string {
+	return f.server.URL + "/{region}/{userPoolId}/.well-known/jwks.json"
+}
+
+// Reset removes all user pools and forgets any requests made
+func (f *FakeCognito) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.jwks = make(map[string]string)
+	f.calls = make(map[string]int)
+}
+
+// SetJWKS sets the JWKS returned for a user pool
+func (f *FakeCognito) SetJWKS(region, userPoolId, jwks string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.jwks[jwksPath(region, userPoolId)] = jwks
+}
+
+// Calls returns the number of JWKS requests made for a user pool
+func (f *FakeCognito) Calls(region, userPoolId string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[jwksPath(region, userPoolId)]
+}
+
+// Close shuts down the FakeCognito
+func (f *FakeCognito) Close() {
+	f.server.Close()
+}
+
+func (f *FakeCognito) serveJWKS(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[req.URL.Path]++
+	jwks, ok := f.jwks[req.URL.Path]
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message":"User pool does not exist."}`)
+		return
+	}
+	fmt.Fprint(w, jwks)
+}
+
+func jwksPath(region, userPoolId string) string {
+	return fmt.Sprintf("/%s/%s/.well-known/jwks.json", region, userPoolId)
+}
@@ -1,23 +1,30 @@
 package steps
 
 import (
+	"fmt"
+	"strconv"
+
 	"github.com/cucumber/godog"
 	"github.com/stretchr/testify/assert"
-	"io/ioutil"
-	"strings"
 )
 
 func (c *Component) RegisterSteps(ctx *godog.ScenarioContext) {
 	c.apiFeature.RegisterSteps(ctx)
 
-	ctx.Step(`^I should receive a hello-world response$`, c.iShouldReceiveAHelloworldResponse)
+	ctx.Step(`^Cognito has the following JWKS for user pool "([^"]*)" in region "([^"]*)":$`, c.cognitoHasTheFollowingJWKS)
+	ctx.Step(`^Cognito should have been asked for the JWKS of user pool "([^"]*)" in region "([^"]*)" (\d+) times?$`, c.cognitoShouldHaveBeenAskedForTheJWKS)
 }
 
-func (c *Component) iShouldReceiveAHelloworldResponse() error {
-	responseBody := c.apiFeature.HttpResponse.Body
-	body, _ := ioutil.ReadAll(responseBody)
-
-	assert.Equal(c, `{"message":"Hello, World!"}`, strings.TrimSpace(string(body)))
+func (c *Component) cognitoHasTheFollowingJWKS(userPoolId, region string, jwks *godog.DocString) error {
+	c.FakeCognito.SetJWKS(region, userPoolId, jwks.Content)
+	return nil
+}
 
+func (c *Component) cognitoShouldHaveBeenAskedForTheJWKS(userPoolId, region, times string) error {
+	expected, err := strconv.Atoi(times)
+	if err != nil {
+		return fmt.Errorf("invalid number of times %q: %w", times, err)
+	}
+	assert.Equal(c, expected, c.FakeCognito.Calls(region, userPoolId))
 	return c.StepError()
 }
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"crypto/x509"
 
 	"github.com/ONSdigital/dp-retrieve-public-signing-keys-aws-cognito/api"
 	"github.com/ONSdigital/dp-retrieve-public-signing-keys-aws-cognito/config"
@@ -16,6 +17,7 @@ type Service struct {
 	Server      HTTPServer
 	Router      *mux.Router
 	Api         *api.API
+	KeyCache    *api.CachingJWKSRetriever
 	ServiceList *ExternalServiceList
 	HealthCheck HealthChecker
 }
@@ -27,6 +29,31 @@ func Run(ctx context.Context, cfg *config.Config, serviceList *ExternalServiceLi
 
 	log.Event(ctx, "using service configuration", log.Data{"config": cfg}, log.INFO)
 
+	policyAction := api.PolicyAction(cfg.JWKSPolicyAction)
+	if policyAction != api.PolicyDrop && policyAction != api.PolicyReject {
+		err := errors.Errorf("invalid key policy action %q: must be %q or %q", cfg.JWKSPolicyAction, api.PolicyDrop, api.PolicyReject)
+		log.Event(ctx, "invalid configuration", log.FATAL, log.Error(err))
+		return nil, err
+	}
+
+	var caRoots *x509.CertPool
+	if cfg.JWKSCABundle != "" {
+		var err error
+		if caRoots, err = api.LoadCABundle(cfg.JWKSCABundle); err != nil {
+			log.Event(ctx, "could not load CA bundle", log.FATAL, log.Error(err))
+			return nil, errors.Wrap(err, "unable to load CA bundle")
+		}
+	}
+
+	var profiles api.CognitoProfiles
+	if cfg.JWTCognitoProfiles != "" {
+		var err error
+		if profiles, err = api.LoadCognitoProfiles(cfg.JWTCognitoProfiles); err != nil {
+			log.Event(ctx, "could not load Cognito profiles", log.FATAL, log.Error(err))
+			return nil, errors.Wrap(err, "unable to load Cognito profiles")
+		}
+	}
+
 	// Get HTTP Server and ... // TODO: Add any middleware that your service requires
 	r := mux.NewRouter()
 
@@ -34,8 +61,45 @@ func Run(ctx context.Context, cfg *config.Config, serviceList *ExternalServiceLi
 
 	// TODO: Add other(s) to serviceList here
 
-	// Setup the API
-	a := api.Setup(ctx, r)
+	// Setup the API, caching converted keys in front of Cognito
+	cognitoClient := api.NewHTTPClient(cfg.JWKSConnectTimeout, cfg.JWKSTLSHandshakeTimeout, cfg.JWKSRequestTimeout)
+	cognito := api.NewCognitoJWKSRetriever(cognitoClient, cfg.JWKSURLTemplate)
+	retrying := api.NewRetryingJWKSRetriever(cognito, api.RetryPolicy{
+		MaxAttempts: cfg.JWKSRetryMaxAttempts,
+		BaseDelay:   cfg.JWKSRetryBaseDelay,
+		MaxDelay:    cfg.JWKSRetryMaxDelay,
+		Jitter:      cfg.JWKSRetryJitter,
+	})
+	breaker := api.NewCircuitBreakingJWKSRetriever(retrying, api.BreakerConfig{
+		FailureThreshold: cfg.JWKSBreakerThreshold,
+		CoolDown:         cfg.JWKSBreakerCoolDown,
+	})
+	keyCache := api.NewCachingJWKSRetriever(breaker, api.CacheConfig{
+		TTL:                cfg.JWKSCacheTTL,
+		MinTTL:             cfg.JWKSCacheMinTTL,
+		MaxTTL:             cfg.JWKSCacheMaxTTL,
+		MaxStale:           cfg.JWKSCacheMaxStale,
+		KidRefreshInterval: cfg.JWKSKidRefreshInterval,
+		Conversion: api.ConversionConfig{
+			MaxBodySize:   cfg.JWKSMaxBodySize,
+			StrictParsing: cfg.JWKSStrictParsing,
+			AllOrNothing:  cfg.JWKSAllOrNothing,
+			StrictKids:    cfg.JWKSStrictKids,
+			CARoots:       caRoots,
+			Policy: api.KeyPolicy{
+				MinRSABits:    cfg.JWKSPolicyMinRSABits,
+				AllowedAlgs:   cfg.JWKSPolicyAllowedAlgs,
+				RequireSigUse: cfg.JWKSPolicyRequireSigUse,
+				WeakExponents: cfg.JWKSPolicyWeakExponents,
+				Action:        policyAction,
+			},
+		},
+	})
+	a := api.Setup(ctx, r, keyCache, api.VerifyConfig{
+		IssuerTemplate: cfg.JWTIssuerTemplate,
+		Leeway:         cfg.JWTLeeway,
+		Profiles:       profiles,
+	})
 
 	hc, err := serviceList.GetHealthCheck(cfg, buildTime, gitCommit, version)
 
@@ -44,13 +108,17 @@ func Run(ctx context.Context, cfg *config.Config, serviceList *ExternalServiceLi
 		return nil, err
 	}
 
-	if err := registerCheckers(ctx, hc); err != nil {
+	if err := registerCheckers(ctx, hc, breaker); err != nil {
 		return nil, errors.Wrap(err, "unable to register checkers")
 	}
 
 	r.StrictSlash(true).Path("/health").HandlerFunc(hc.Handler)
 	hc.Start(ctx)
 
+	if cfg.JWKSRefreshInterval > 0 {
+		keyCache.StartRefresher(cfg.JWKSRefreshInterval)
+	}
+
 	// Run the http server in a new go-routine
 	go func() {
 		if err := s.ListenAndServe(); err != nil {
@@ -62,6 +130,7 @@ func Run(ctx context.Context, cfg *config.Config, serviceList *ExternalServiceLi
 		Config:      cfg,
 		Router:      r,
 		Api:         a,
+		KeyCache:    keyCache,
 		HealthCheck: hc,
 		ServiceList: serviceList,
 		Server:      s,
@@ -91,6 +160,11 @@ func (svc *Service) Close(ctx context.Context) error {
 			hasShutdownError = true
 		}
 
+		// stop refreshing cached keys once nothing can be asking for them
+		if svc.KeyCache != nil {
+			svc.KeyCache.StopRefresher()
+		}
+
 		// TODO: Close other dependencies, in the expected order
 	}()
 
@@ -115,9 +189,19 @@ func (svc *Service) Close(ctx context.Context) error {
 }
 
 func registerCheckers(ctx context.Context,
-	hc HealthChecker) (err error) {
+	hc HealthChecker,
+	breaker *api.CircuitBreakingJWKSRetriever) (err error) {
 
-	// TODO: add other health checks here, as per dp-upload-service
+	hasErrors := false
+
+	if err = hc.AddCheck("AWS Cognito circuit breakers", breaker.Checker); err != nil {
+		hasErrors = true
+		log.Event(ctx, "error adding check for aws cognito circuit breakers", log.ERROR, log.Error(err))
+	}
+
+	if hasErrors {
+		return errors.New("Error(s) registering checkers for healthcheck")
+	}
 
 	return nil
 }
@@ -99,6 +99,72 @@ func TestRun(t *testing.T) {
 			})
 		})
 
+		Convey("Given that the key policy action is invalid", func() {
+
+			initMock := &serviceMock.InitialiserMock{
+				DoGetHTTPServerFunc:  funcDoGetHTTPServer,
+				DoGetHealthCheckFunc: funcDoGetHealthcheckOk,
+			}
+			svcErrors := make(chan error, 1)
+			svcList := service.NewServiceList(initMock)
+			cfg.JWKSPolicyAction = "ignore"
+			_, err := service.Run(ctx, cfg, svcList, testBuildTime, testGitCommit, testVersion, svcErrors)
+
+			Convey("Then service Run fails before starting anything", func() {
+				So(err.Error(), ShouldEqual, `invalid key policy action "ignore": must be "drop" or "reject"`)
+				So(len(initMock.DoGetHTTPServerCalls()), ShouldEqual, 0)
+				So(len(initMock.DoGetHealthCheckCalls()), ShouldEqual, 0)
+			})
+
+			Reset(func() {
+				cfg.JWKSPolicyAction = "drop"
+			})
+		})
+
+		Convey("Given that the CA bundle cannot be loaded", func() {
+
+			initMock := &serviceMock.InitialiserMock{
+				DoGetHTTPServerFunc:  funcDoGetHTTPServer,
+				DoGetHealthCheckFunc: funcDoGetHealthcheckOk,
+			}
+			svcErrors := make(chan error, 1)
+			svcList := service.NewServiceList(initMock)
+			cfg.JWKSCABundle = "testdata/does-not-exist.pem"
+			_, err := service.Run(ctx, cfg, svcList, testBuildTime, testGitCommit, testVersion, svcErrors)
+
+			Convey("Then service Run fails before starting anything", func() {
+				So(err.Error(), ShouldStartWith, "unable to load CA bundle")
+				So(len(initMock.DoGetHTTPServerCalls()), ShouldEqual, 0)
+				So(len(initMock.DoGetHealthCheckCalls()), ShouldEqual, 0)
+			})
+
+			Reset(func() {
+				cfg.JWKSCABundle = ""
+			})
+		})
+
+		Convey("Given that the Cognito profiles cannot be loaded", func() {
+
+			initMock := &serviceMock.InitialiserMock{
+				DoGetHTTPServerFunc:  funcDoGetHTTPServer,
+				DoGetHealthCheckFunc: funcDoGetHealthcheckOk,
+			}
+			svcErrors := make(chan error, 1)
+			svcList := service.NewServiceList(initMock)
+			cfg.JWTCognitoProfiles = "testdata/does-not-exist.json"
+			_, err := service.Run(ctx, cfg, svcList, testBuildTime, testGitCommit, testVersion, svcErrors)
+
+			Convey("Then service Run fails before starting anything", func() {
+				So(err.Error(), ShouldStartWith, "unable to load Cognito profiles")
+				So(len(initMock.DoGetHTTPServerCalls()), ShouldEqual, 0)
+				So(len(initMock.DoGetHealthCheckCalls()), ShouldEqual, 0)
+			})
+
+			Reset(func() {
+				cfg.JWTCognitoProfiles = ""
+			})
+		})
+
 		Convey("Given that all dependencies are successfully initialised", func() {
 
 			// setup (run before each `Convey` at this scope / indentation):
@@ -117,7 +183,8 @@ func TestRun(t *testing.T) {
 			})
 
 			Convey("The checkers are registered and the healthcheck and http server started", func() {
-				So(len(hcMock.AddCheckCalls()), ShouldEqual, 0)
+				So(len(hcMock.AddCheckCalls()), ShouldEqual, 1)
+				So(hcMock.AddCheckCalls()[0].Name, ShouldEqual, "AWS Cognito circuit breakers")
 				So(len(initMock.DoGetHTTPServerCalls()), ShouldEqual, 1)
 				So(initMock.DoGetHTTPServerCalls()[0].BindAddr, ShouldEqual, "localhost:25999")
 				So(len(hcMock.StartCalls()), ShouldEqual, 1)
@@ -131,8 +198,7 @@ func TestRun(t *testing.T) {
 			})
 		})
 
-		// ADD CODE: put this code in, if you have Checkers to register
-		/*Convey("Given that Checkers cannot be registered", func() {
+		Convey("Given that Checkers cannot be registered", func() {
 
 			// setup (run before each `Convey` at this scope / indentation):
 			errAddheckFail := errors.New("Error(s) registering checkers for healthcheck")
@@ -146,23 +212,21 @@ func TestRun(t *testing.T) {
 				DoGetHealthCheckFunc: func(cfg *config.Config, buildTime string, gitCommit string, version string) (service.HealthChecker, error) {
 					return hcMockAddFail, nil
 				},
-				// ADD CODE: add the checkers that you want to register here
 			}
 			svcErrors := make(chan error, 1)
 			svcList := service.NewServiceList(initMock)
 			_, err := service.Run(ctx, cfg, svcList, testBuildTime, testGitCommit, testVersion, svcErrors)
 
 			Convey("Then service Run fails, but all checks try to register", func() {
-				So(err, ShouldBeNil)
+				So(err, ShouldNotBeNil)
 				So(err.Error(), ShouldResemble, fmt.Sprintf("unable to register checkers: %s", errAddheckFail.Error()))
 				So(svcList.HealthCheck, ShouldBeTrue)
-				// ADD CODE: add code to confirm checkers exist
-				So(len(hcMockAddFail.AddCheckCalls()), ShouldEqual, 0) // ADD CODE: change the '0' to the number of checkers you have registered
+				So(len(hcMockAddFail.AddCheckCalls()), ShouldEqual, 1)
 			})
 			Reset(func() {
 				// This reset is run after each `Convey` at the same scope (indentation)
 			})
-		})*/
+		})
 
 		Convey("Given that all dependencies are successfully initialised but the http server fails", func() {
 